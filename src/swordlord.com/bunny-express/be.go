@@ -38,6 +38,7 @@ import (
 	"swordlord.com/bunny-express/cmd"
 	"swordlord.com/bunny-express/common"
 	"swordlord.com/bunny-express/db"
+	"swordlord.com/bunny-express/dovecot"
 )
 
 func main() {
@@ -45,18 +46,27 @@ func main() {
 	common.InitConfig()
 	common.InitLog()
 
-	db.CheckDatabase()
+	quiet := cmd.IsQuiet(os.Args[1:])
+	if quiet {
+		common.SetLogOutput(os.Stderr)
+	}
 
-	fmt.Println(` ______                           _______                                        `)
-	fmt.Println(`|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.    (\(\`)
-	fmt.Println(`|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|   ( =':')`)
-	fmt.Println(`|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|   (..(")(")`)
-	fmt.Println(`                          |_____|               |__|                             `)
+	// only help and be db status / migrate work with pending migrations
+	if !db.CheckDatabase() && !cmd.RunsOnOutdatedSchema(os.Args[1:]) {
 
-	//	fmt.Println("")
-	//	fmt.Println("CLI based mailbox configuration for Postfix and Dovecot")
-	fmt.Println("(c) 2018-19 by SwordLord - the coding crew")
-	fmt.Println("")
+		fmt.Fprintln(os.Stderr, "[-] The database schema is outdated, run 'be db migrate' first.")
+
+		// quiet commands are called by dovecot / postfix, make them retry
+		// instead of taking this as a failed login
+		if quiet {
+			os.Exit(dovecot.CheckpasswordTempFail)
+		}
+		os.Exit(1)
+	}
+
+	if !quiet {
+		printBanner()
+	}
 
 	// initialise the command structure
 	if err := cmd.RootCmd.Execute(); err != nil {
@@ -69,5 +79,16 @@ func main() {
 	}
 }
 
-// TODO: alias -> forward -> multi line, one line per address
-// TODO: @ in alias == catchall? -> fix in sql syntax
+func printBanner() {
+
+	fmt.Println(` ______                           _______                                        `)
+	fmt.Println(`|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.    (\(\`)
+	fmt.Println(`|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|   ( =':')`)
+	fmt.Println(`|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|   (..(")(")`)
+	fmt.Println(`                          |_____|               |__|                             `)
+
+	//	fmt.Println("")
+	//	fmt.Println("CLI based mailbox configuration for Postfix and Dovecot")
+	fmt.Println("(c) 2018-19 by SwordLord - the coding crew")
+	fmt.Println("")
+}
@@ -37,7 +37,9 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"strconv"
+	"strings"
 	"swordlord.com/bunny-express/db/alias"
+	"swordlord.com/bunny-express/db/domain"
 	"swordlord.com/bunny-express/util"
 )
 
@@ -60,7 +62,7 @@ func ListAliases(cmd *cobra.Command, args []string) error {
 
 	fForward := cmd.Flag("forward")
 	if fForward.Changed {
-		af.ForwardAddress = fForward.Value.String()
+		af.Target = fForward.Value.String()
 	}
 
 	aa, err := alias.GetFilteredAliases(&af)
@@ -72,7 +74,7 @@ func ListAliases(cmd *cobra.Command, args []string) error {
 
 	for _, a := range aa {
 
-		aliases = append(aliases, []string{a.Alias, a.Description.String, a.Domain, a.ForwardAddress, strconv.FormatBool(a.IsActive), a.CrtDat.Format("2006-01-02 15:04:05"), a.UpdDat.Format("2006-01-02 15:04:05")})
+		aliases = append(aliases, []string{a.Alias, a.Description.String, a.Domain, strings.Join(a.Targets, ", "), strconv.FormatBool(a.IsActive), a.CrtDat.Format("2006-01-02 15:04:05"), a.UpdDat.Format("2006-01-02 15:04:05")})
 	}
 
 	util.WriteTable(alias.GetFieldCaptions(), aliases)
@@ -86,7 +88,11 @@ func AddAlias(cmd *cobra.Command, args []string) error {
 
 	a.SetAlias(args[0])
 	a.SetDomain(args[1])
-	a.SetForwardAddress(args[2])
+
+	err := a.SetTargets(alias.ParseTargets(strings.Join(args[2:], " ")))
+	if err != nil {
+		return fmt.Errorf("command 'add' returns an error %s", err)
+	}
 
 	scanAliasFlagsToObject(cmd, a)
 
@@ -132,6 +138,152 @@ func DeleteAlias(cmd *cobra.Command, args []string) error {
 	return alias.DeleteAlias(args[0])
 }
 
+func SetCatchAll(cmd *cobra.Command, args []string) error {
+
+	_, err := domain.GetDomain(args[0])
+	if err != nil {
+		return fmt.Errorf("command 'set' returns an error domain %s not found", args[0])
+	}
+
+	a, err := alias.GetCatchAll(args[0])
+	if err == sql.ErrNoRows {
+		a = alias.NewAlias()
+		a.SetAlias(alias.GetCatchAllAddress(args[0]))
+		a.SetDomain(args[0])
+	} else if err != nil {
+		return fmt.Errorf("command 'set' returns an error %s", err)
+	}
+
+	err = a.SetTargets(alias.ParseTargets(strings.Join(args[1:], " ")))
+	if err != nil {
+		return fmt.Errorf("command 'set' returns an error %s", err)
+	}
+
+	scanAliasFlagsToObject(cmd, a)
+
+	return a.Persist()
+}
+
+func UnsetCatchAll(cmd *cobra.Command, args []string) error {
+
+	_, err := alias.GetCatchAll(args[0])
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("command 'unset' returns an error domain %s has no catch-all", args[0])
+	} else if err != nil {
+		return fmt.Errorf("command 'unset' returns an error %s", err)
+	}
+
+	return alias.DeleteAlias(alias.GetCatchAllAddress(args[0]))
+}
+
+func ShowCatchAll(cmd *cobra.Command, args []string) error {
+
+	var aa []alias.Alias
+
+	if len(args) > 0 {
+
+		a, err := alias.GetCatchAll(args[0])
+		if err == sql.ErrNoRows {
+			fmt.Printf("Domain %s has no catch-all.\n", args[0])
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("command 'show' returns an error %s", err)
+		}
+
+		aa = append(aa, *a)
+
+	} else {
+
+		var err error
+		aa, err = alias.GetCatchAlls()
+		if err != nil {
+			return fmt.Errorf("command 'show' returns an error %s", err)
+		}
+	}
+
+	var aliases [][]string
+
+	for _, a := range aa {
+
+		aliases = append(aliases, []string{a.Alias, a.Description.String, a.Domain, strings.Join(a.Targets, ", "), strconv.FormatBool(a.IsActive), a.CrtDat.Format("2006-01-02 15:04:05"), a.UpdDat.Format("2006-01-02 15:04:05")})
+	}
+
+	util.WriteTable(alias.GetFieldCaptions(), aliases)
+
+	return nil
+}
+
+func AddAliasTarget(cmd *cobra.Command, args []string) error {
+
+	a, err := alias.GetAlias(args[0])
+	if err != nil {
+		return fmt.Errorf("command 'add' returns an error alias %s not found", args[0])
+	}
+
+	for _, t := range alias.ParseTargets(strings.Join(args[1:], " ")) {
+
+		err = a.AddTarget(t)
+		if err != nil {
+			return fmt.Errorf("command 'add' returns an error %s", err)
+		}
+	}
+
+	return a.Persist()
+}
+
+func RemoveAliasTarget(cmd *cobra.Command, args []string) error {
+
+	a, err := alias.GetAlias(args[0])
+	if err != nil {
+		return fmt.Errorf("command 'remove' returns an error alias %s not found", args[0])
+	}
+
+	for _, t := range alias.ParseTargets(strings.Join(args[1:], " ")) {
+
+		err = a.RemoveTarget(t)
+		if err != nil {
+			return fmt.Errorf("command 'remove' returns an error %s", err)
+		}
+	}
+
+	return a.Persist()
+}
+
+func ListAliasTargets(cmd *cobra.Command, args []string) error {
+
+	var aa []alias.Alias
+
+	if len(args) > 0 {
+
+		a, err := alias.GetAlias(args[0])
+		if err != nil {
+			return fmt.Errorf("command 'list' returns an error alias %s not found", args[0])
+		}
+
+		aa = append(aa, *a)
+
+	} else {
+
+		var err error
+		aa, err = alias.GetAllAliases()
+		if err != nil {
+			return fmt.Errorf("command 'list' returns an error %s", err)
+		}
+	}
+
+	var rows [][]string
+
+	for _, a := range aa {
+		for _, t := range a.Targets {
+			rows = append(rows, []string{a.Alias, t})
+		}
+	}
+
+	util.WriteTable([]string{"Alias", "Target"}, rows)
+
+	return nil
+}
+
 func init() {
 
 	// calCmd represents the domain command
@@ -151,16 +303,16 @@ wildcard character to return all matching aliases.`,
 	}
 	aliasListCmd.Flags().BoolP("active", "a", true, "is alias active?")
 	aliasListCmd.Flags().StringP("domain", "d", "", "alias for which domain")
-	aliasListCmd.Flags().StringP("forward", "f", "", "alias pointing to which forward address")
+	aliasListCmd.Flags().StringP("forward", "f", "", "alias pointing to which target address")
 
 	var aliasAddCmd = &cobra.Command{
-		Use:   "add [alias] [domain] [forward_address]",
+		Use:   "add [alias] [domain] [target]...",
 		Short: "Add new alias to given domain",
 		Long: `Add new alias with parameters given and add it to the given domain.
 
-The field forward_address can contain multiple addresses. 
-Please make sure to add a blank between the addresses.`,
-		Args: cobra.ExactArgs(3),
+An alias can have multiple targets, give one address per argument, or a list 
+separated by blanks or commas. Use 'be alias target' to change them later.`,
+		Args: cobra.MinimumNArgs(3),
 		RunE: AddAlias,
 	}
 	aliasAddCmd.Flags().BoolP("active", "a", true, "is alias active")
@@ -193,10 +345,89 @@ Please make sure to add a blank between the addresses.`,
 		RunE:  DeleteAlias,
 	}
 
+	var aliasCatchAllCmd = &cobra.Command{
+		Use:   "catchall",
+		Short: "Set, unset and show the catch-all of a domain",
+		Long: `Set, unset and show the catch-all of a domain. Requires a subcommand.
+
+The catch-all @domain gets all mail to addresses of the domain which are neither 
+a mailbox nor an alias. There is one catch-all per domain at most.`,
+		RunE: nil,
+	}
+
+	var aliasCatchAllSetCmd = &cobra.Command{
+		Use:   "set [domain] [target]...",
+		Short: "Set the catch-all of the domain",
+		Long: `Set the forward addresses of the catch-all of the domain, the catch-all is 
+added if the domain has none yet.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: SetCatchAll,
+	}
+	aliasCatchAllSetCmd.Flags().BoolP("active", "a", true, "is catch-all active")
+	aliasCatchAllSetCmd.Flags().StringP("description", "d", "", "description for this catch-all")
+
+	var aliasCatchAllUnsetCmd = &cobra.Command{
+		Use:   "unset [domain]",
+		Short: "Remove the catch-all of the domain",
+		Long:  `Remove the catch-all of the domain. Will return an error if the domain has none.`,
+		Args:  cobra.ExactArgs(1),
+		RunE:  UnsetCatchAll,
+	}
+
+	var aliasCatchAllShowCmd = &cobra.Command{
+		Use:   "show [domain]",
+		Short: "Show the catch-all of the domain",
+		Long:  `Show the catch-all of the domain, or the ones of all domains if none is given.`,
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  ShowCatchAll,
+	}
+
+	var aliasTargetCmd = &cobra.Command{
+		Use:   "target",
+		Short: "Add, remove and list the targets of aliases",
+		Long:  `Add, remove and list the addresses an alias forwards to. Requires a subcommand.`,
+		RunE:  nil,
+	}
+
+	var aliasTargetAddCmd = &cobra.Command{
+		Use:   "add [alias] [target]...",
+		Short: "Add targets to the alias",
+		Long:  `Add one or more target addresses to the alias. Will return an error if a target is invalid or already there.`,
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  AddAliasTarget,
+	}
+
+	var aliasTargetRemoveCmd = &cobra.Command{
+		Use:   "remove [alias] [target]...",
+		Short: "Remove targets from the alias",
+		Long: `Remove one or more target addresses from the alias. The last target can't be 
+removed, delete the alias instead.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: RemoveAliasTarget,
+	}
+
+	var aliasTargetListCmd = &cobra.Command{
+		Use:   "list [alias]",
+		Short: "List the targets of the alias",
+		Long:  `List the targets of the alias, or the ones of all aliases if none is given.`,
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  ListAliasTargets,
+	}
+
 	RootCmd.AddCommand(aliasCmd)
 
 	aliasCmd.AddCommand(aliasListCmd)
 	aliasCmd.AddCommand(aliasAddCmd)
 	aliasCmd.AddCommand(aliasEditCmd)
 	aliasCmd.AddCommand(aliasDeleteCmd)
+	aliasCmd.AddCommand(aliasCatchAllCmd)
+	aliasCmd.AddCommand(aliasTargetCmd)
+
+	aliasCatchAllCmd.AddCommand(aliasCatchAllSetCmd)
+	aliasCatchAllCmd.AddCommand(aliasCatchAllUnsetCmd)
+	aliasCatchAllCmd.AddCommand(aliasCatchAllShowCmd)
+
+	aliasTargetCmd.AddCommand(aliasTargetAddCmd)
+	aliasTargetCmd.AddCommand(aliasTargetRemoveCmd)
+	aliasTargetCmd.AddCommand(aliasTargetListCmd)
 }
@@ -0,0 +1,131 @@
+package cmd
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/dovecot"
+	"syscall"
+)
+
+// checkpassword protocol: credentials on fd 3, exec the reply program on success,
+// exit with 1 (failed) or 111 (temporary problem) otherwise
+func Checkpassword(cmd *cobra.Command, args []string) error {
+
+	reply, err := exec.LookPath(args[0])
+	if err != nil {
+		common.LogError("Checkpassword: reply program not found.", logrus.Fields{"program": args[0], "error": err})
+		os.Exit(dovecot.CheckpasswordTempFail)
+	}
+
+	fd3 := os.NewFile(3, "checkpassword")
+	if fd3 == nil {
+		common.LogError("Checkpassword: fd 3 not open.", nil)
+		os.Exit(dovecot.CheckpasswordTempFail)
+	}
+	defer fd3.Close()
+
+	env, err := dovecot.Checkpassword(fd3, os.Getenv("AUTHORIZED") == "1")
+	if err != nil {
+
+		code := dovecot.CheckpasswordTempFail
+		if cpErr, ok := err.(*dovecot.CheckpasswordError); ok {
+			code = cpErr.ExitCode
+		}
+
+		os.Exit(code)
+	}
+
+	err = syscall.Exec(reply, args, mergeEnv(os.Environ(), env))
+
+	// only returns on error
+	return fmt.Errorf("command 'checkpassword' could not run reply program %s", err)
+}
+
+// overrides variables of environ with the ones of env, getenv would use the first one found
+func mergeEnv(environ []string, env []string) []string {
+
+	set := make(map[string]bool)
+	for _, e := range env {
+		set[strings.SplitN(e, "=", 2)[0]] = true
+	}
+
+	var merged []string
+	for _, e := range environ {
+		if !set[strings.SplitN(e, "=", 2)[0]] {
+			merged = append(merged, e)
+		}
+	}
+
+	return append(merged, env...)
+}
+
+func init() {
+
+	var authCmd = &cobra.Command{
+		Use:   "auth",
+		Short: "Authenticate mail users for other programs.",
+		Long:  `Authenticate mail users for other programs. Requires a subcommand.`,
+		RunE:  nil,
+	}
+
+	var authCheckpasswordCmd = &cobra.Command{
+		Use:   "checkpassword [reply program] [args]",
+		Short: "Authenticate Dovecot users with the checkpassword protocol",
+		Long: `Authenticate Dovecot users with the checkpassword protocol. Use within Dovecot as
+
+passdb {
+  driver = checkpassword
+  args = /usr/local/bin/be auth checkpassword
+}
+
+Username and password are read from fd 3. On success the reply program is executed 
+with USER, HOME and userdb_quota_rule set. Exits with 1 if authentication failed and 
+111 on temporary problems. To test locally:
+
+printf 'user@example.com\0secret\0' | be auth checkpassword /usr/bin/env 3<&0`,
+		Args:        cobra.MinimumNArgs(1),
+		Annotations: map[string]string{annotationQuiet: "true"},
+		RunE:        Checkpassword,
+	}
+
+	RootCmd.AddCommand(authCmd)
+
+	authCmd.AddCommand(authCheckpasswordCmd)
+}
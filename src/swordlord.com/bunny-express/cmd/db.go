@@ -0,0 +1,118 @@
+package cmd
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"strconv"
+	"swordlord.com/bunny-express/db"
+	"swordlord.com/bunny-express/util"
+)
+
+func ShowDatabaseStatus(cmd *cobra.Command, args []string) error {
+
+	states, err := db.GetMigrationStates()
+	if err != nil {
+		return fmt.Errorf("command 'status' returns an error %s", err)
+	}
+
+	var rows [][]string
+
+	for _, s := range states {
+
+		applied := "pending"
+		if s.IsApplied {
+			applied = s.AppliedDat.Format("2006-01-02 15:04:05")
+		}
+
+		rows = append(rows, []string{strconv.Itoa(s.Version), s.Description, applied})
+	}
+
+	util.WriteTable([]string{"Version", "Description", "Applied"}, rows)
+
+	return nil
+}
+
+func MigrateDatabase(cmd *cobra.Command, args []string) error {
+
+	backup, count, err := db.Migrate()
+	if err != nil {
+		return fmt.Errorf("command 'migrate' returns an error %s", err)
+	}
+
+	if count == 0 {
+		fmt.Println("Database schema is up to date, nothing to migrate.")
+		return nil
+	}
+
+	fmt.Printf("Database backed up to %s\n", backup)
+	fmt.Printf("%d migration(s) applied, schema is now at version %d.\n", count, db.GetLatestSchemaVersion())
+
+	return nil
+}
+
+func init() {
+
+	var dbCmd = &cobra.Command{
+		Use:   "db",
+		Short: "Show and upgrade the database schema.",
+		Long:  `Show and upgrade the database schema. Requires a subcommand.`,
+		RunE:  nil,
+	}
+
+	var dbStatusCmd = &cobra.Command{
+		Use:         "status",
+		Short:       "Show applied and pending schema migrations",
+		Long:        `Show all schema migrations known to this version of bunnyexpress and when they were applied.`,
+		Args:        cobra.NoArgs,
+		RunE:        ShowDatabaseStatus,
+		Annotations: map[string]string{annotationOutdatedSchema: "true"},
+	}
+
+	var dbMigrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the database schema",
+		Long: `Upgrade the database schema to the version this binary expects. 
+
+A copy of the database file is written next to it before the first migration runs.`,
+		Args:        cobra.NoArgs,
+		RunE:        MigrateDatabase,
+		Annotations: map[string]string{annotationOutdatedSchema: "true"},
+	}
+
+	RootCmd.AddCommand(dbCmd)
+
+	dbCmd.AddCommand(dbStatusCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+}
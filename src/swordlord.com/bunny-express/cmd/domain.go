@@ -42,6 +42,7 @@ import (
 	"strconv"
 	"swordlord.com/bunny-express/common"
 	"swordlord.com/bunny-express/db/alias"
+	"swordlord.com/bunny-express/db/aliasdomain"
 	"swordlord.com/bunny-express/db/domain"
 	"swordlord.com/bunny-express/util"
 )
@@ -73,8 +74,12 @@ func ListDomain(cmd *cobra.Command, args []string) error {
 	for _, domain := range d {
 
 		domains = append(domains, []string{domain.GetDomain(), domain.GetDescription().String,
-			strconv.Itoa(domain.GetMailboxCount()),
-			strconv.Itoa(domain.GetAliasCount()),
+			domain.GetType(),
+			domain.GetTransport().String,
+			formatUsage(int64(domain.GetMailboxCount()), int64(domain.GetMaxMailboxes())),
+			formatUsage(int64(domain.GetAliasCount()), int64(domain.GetMaxAliases())),
+			formatLimit(domain.GetMaxQuotaPerMailbox()),
+			formatUsage(domain.GetTotalQuota(), domain.GetMaxTotalQuota()),
 			strconv.FormatBool(domain.GetIsActive()),
 			domain.CrtDat.Format("2006-01-02 15:04:05"),
 			domain.UpdDat.Format("2006-01-02 15:04:05")})
@@ -85,15 +90,43 @@ func ListDomain(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// used/max, or just used without a limit
+func formatUsage(used int64, max int64) string {
+
+	// a quota which is no size
+	if used < 0 {
+		return "?/" + formatLimit(max)
+	}
+
+	if max == 0 {
+		return strconv.FormatInt(used, 10)
+	}
+
+	return strconv.FormatInt(used, 10) + "/" + strconv.FormatInt(max, 10)
+}
+
+// - for no limit
+func formatLimit(max int64) string {
+
+	if max == 0 {
+		return "-"
+	}
+
+	return strconv.FormatInt(max, 10)
+}
+
 func AddDomain(cmd *cobra.Command, args []string) error {
 
 	d := domain.NewDomain()
 
 	d.SetDomain(args[0])
 
-	scanDomainFlagsToObject(cmd, d)
+	err := scanDomainFlagsToObject(cmd, d)
+	if err != nil {
+		return err
+	}
 
-	err := d.Persist()
+	err = d.Persist()
 	if err != nil {
 		return err
 	}
@@ -119,12 +152,15 @@ func EditDomain(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("command 'edit' returns an error %s", err)
 	}
 
-	scanDomainFlagsToObject(cmd, d)
+	err = scanDomainFlagsToObject(cmd, d)
+	if err != nil {
+		return err
+	}
 
 	return d.Persist()
 }
 
-func scanDomainFlagsToObject(cmd *cobra.Command, d *domain.Domain) {
+func scanDomainFlagsToObject(cmd *cobra.Command, d *domain.Domain) error {
 
 	fActive := cmd.Flag("active")
 	if fActive.Changed {
@@ -144,6 +180,47 @@ func scanDomainFlagsToObject(cmd *cobra.Command, d *domain.Domain) {
 			d.SetDescription(s)
 		}
 	}
+
+	fType := cmd.Flag("type")
+	if fType.Changed {
+		d.SetType(fType.Value.String())
+	}
+
+	fTransport := cmd.Flag("transport")
+	if fTransport.Changed {
+
+		var s = sql.NullString{}
+		err := s.Scan(fTransport.Value.String())
+		if err == nil {
+			d.SetTransport(s)
+		}
+	}
+
+	limits := []struct {
+		flag string
+		set  func(int64)
+	}{
+		{"maxmailboxes", func(max int64) { d.SetMaxMailboxes(int(max)) }},
+		{"maxaliases", func(max int64) { d.SetMaxAliases(int(max)) }},
+		{"maxquota", d.SetMaxQuotaPerMailbox},
+		{"maxtotalquota", d.SetMaxTotalQuota},
+	}
+
+	for _, l := range limits {
+
+		fLimit := cmd.Flag(l.flag)
+		if fLimit.Changed {
+
+			max, err := strconv.ParseInt(fLimit.Value.String(), 10, 64)
+			if err != nil || max < 0 {
+				return fmt.Errorf("%s must be 0 or a positive number", l.flag)
+			}
+
+			l.set(max)
+		}
+	}
+
+	return nil
 }
 
 func DeleteDomain(cmd *cobra.Command, args []string) error {
@@ -151,6 +228,55 @@ func DeleteDomain(cmd *cobra.Command, args []string) error {
 	return domain.DeleteDomain(args[0])
 }
 
+func ListAliasDomain(cmd *cobra.Command, args []string) error {
+
+	target := ""
+	if len(args) > 0 {
+		target = args[0]
+	}
+
+	ad, err := aliasdomain.GetAliasDomains(target)
+	if err != nil {
+		return fmt.Errorf("command 'list' returns an error %s", err)
+	}
+
+	var aliasDomains [][]string
+
+	for _, a := range ad {
+
+		aliasDomains = append(aliasDomains, []string{a.AliasDomain, a.TargetDomain, a.CrtDat.Format("2006-01-02 15:04:05")})
+	}
+
+	util.WriteTable(aliasdomain.GetFieldCaptions(), aliasDomains)
+
+	return nil
+}
+
+func AddAliasDomain(cmd *cobra.Command, args []string) error {
+
+	return aliasdomain.AddAliasDomain(args[0], args[1])
+}
+
+func DeleteAliasDomain(cmd *cobra.Command, args []string) error {
+
+	_, err := aliasdomain.GetAliasDomain(args[0])
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s is no alias domain", args[0])
+	} else if err != nil {
+		return fmt.Errorf("command 'delete' returns an error %s", err)
+	}
+
+	return aliasdomain.DeleteAliasDomain(args[0])
+}
+
+func addDomainLimitFlags(cmd *cobra.Command) {
+
+	cmd.Flags().Int("maxmailboxes", 0, "max number of mailboxes, 0 for no limit")
+	cmd.Flags().Int("maxaliases", 0, "max number of aliases, 0 for no limit")
+	cmd.Flags().Int64("maxquota", 0, "max quota per mailbox in bytes, 0 for no limit")
+	cmd.Flags().Int64("maxtotalquota", 0, "max quota of all mailboxes together in bytes, 0 for no limit")
+}
+
 func init() {
 
 	// calCmd represents the domain command
@@ -173,12 +299,27 @@ func init() {
 	var domainAddCmd = &cobra.Command{
 		Use:   "add [domain]",
 		Short: "Add new domain",
-		Long:  `Add new domain with parameters given.`,
-		Args:  cobra.ExactArgs(1),
-		RunE:  AddDomain,
+		Long: `Add new domain with parameters given. The type tells how mail for the domain 
+is handled:
+
+virtual   mailboxes and aliases, delivered by virtual_transport (default)
+relay     relayed to the transport of the domain, listed in relay_domains
+backupmx  queued as backup MX for the primary one, listed in relay_domains
+alias     aliases only, no mailboxes
+
+The transport is written to transport_maps, e.g. smtp:[host]:25 or 
+lmtp:unix:private/dovecot-lmtp.
+
+The limits are checked whenever a mailbox or alias is added or a quota changed. 
+With a quota limit set, every mailbox of the domain needs a quota.`,
+		Args: cobra.ExactArgs(1),
+		RunE: AddDomain,
 	}
 	domainAddCmd.Flags().BoolP("active", "a", true, "is domain active")
 	domainAddCmd.Flags().StringP("description", "d", "", "description for this domain")
+	domainAddCmd.Flags().StringP("type", "t", "virtual", "virtual, relay, backupmx or alias")
+	domainAddCmd.Flags().StringP("transport", "r", "", "transport_maps entry, e.g. smtp:[host]:25")
+	addDomainLimitFlags(domainAddCmd)
 	domainAddCmd.Flags().BoolP("fill", "f", false, "add default aliases to the new domain")
 
 	var domainEditCmd = &cobra.Command{
@@ -190,15 +331,54 @@ func init() {
 	}
 	domainEditCmd.Flags().BoolP("active", "a", true, "is domain active")
 	domainEditCmd.Flags().StringP("description", "d", "", "description for this domain")
+	domainEditCmd.Flags().StringP("type", "t", "virtual", "virtual, relay, backupmx or alias")
+	domainEditCmd.Flags().StringP("transport", "r", "", "transport_maps entry, e.g. smtp:[host]:25, empty for none")
+	addDomainLimitFlags(domainEditCmd)
 
 	var domainDeleteCmd = &cobra.Command{
 		Use:   "delete [domain]",
 		Short: "Deletes a domain.",
-		Long:  `Deletes a domain.`,
+		Long:  `Deletes a domain. Alias domains pointing to it have to be deleted first.`,
 		Args:  cobra.ExactArgs(1),
 		RunE:  DeleteDomain,
 	}
 
+	var domainAliasCmd = &cobra.Command{
+		Use:   "alias",
+		Short: "Add, list and delete alias domains",
+		Long: `Add, list and delete alias domains. Requires a subcommand.
+
+Mail to user@alias-domain is delivered to user@target-domain, as long as that
+address is a mailbox or an alias, or the target domain has a catch-all. Users
+can log in with either address.`,
+		RunE: nil,
+	}
+
+	var domainAliasListCmd = &cobra.Command{
+		Use:   "list [target-domain]",
+		Short: "List alias domains",
+		Long:  `List all alias domains, or the ones of the target domain if given.`,
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  ListAliasDomain,
+	}
+
+	var domainAliasAddCmd = &cobra.Command{
+		Use:   "add [alias-domain] [target-domain]",
+		Short: "Add an alias domain",
+		Long: `Add an alias domain pointing to the target domain. The target has to be an 
+existing domain, the alias domain must not be one.`,
+		Args: cobra.ExactArgs(2),
+		RunE: AddAliasDomain,
+	}
+
+	var domainAliasDeleteCmd = &cobra.Command{
+		Use:   "delete [alias-domain]",
+		Short: "Delete an alias domain",
+		Long:  `Delete an alias domain. Will return an error if it is not found.`,
+		Args:  cobra.ExactArgs(1),
+		RunE:  DeleteAliasDomain,
+	}
+
 	flag.Parse()
 
 	RootCmd.AddCommand(domainCmd)
@@ -207,4 +387,9 @@ func init() {
 	domainCmd.AddCommand(domainAddCmd)
 	domainCmd.AddCommand(domainEditCmd)
 	domainCmd.AddCommand(domainDeleteCmd)
+	domainCmd.AddCommand(domainAliasCmd)
+
+	domainAliasCmd.AddCommand(domainAliasListCmd)
+	domainAliasCmd.AddCommand(domainAliasAddCmd)
+	domainAliasCmd.AddCommand(domainAliasDeleteCmd)
 }
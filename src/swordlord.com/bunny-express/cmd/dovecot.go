@@ -0,0 +1,83 @@
+package cmd
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"swordlord.com/bunny-express/dovecot"
+)
+
+func WriteDovecotConfig(cmd *cobra.Command, args []string) error {
+
+	dir := cmd.Flag("dir").Value.String()
+
+	file, err := dovecot.WriteSQLConfig(dir)
+	if err != nil {
+		return fmt.Errorf("command 'config' returns an error %s", err)
+	}
+
+	fmt.Printf("%s written. Add these sections to your Dovecot config:\n", file)
+	fmt.Println("")
+	fmt.Printf("passdb {\n  driver = sql\n  args = %s\n}\n", file)
+	fmt.Printf("userdb {\n  driver = sql\n  args = %s\n}\n", file)
+
+	return nil
+}
+
+func init() {
+
+	var dovecotCmd = &cobra.Command{
+		Use:   "dovecot",
+		Short: "Integrate bunnyexpress with Dovecot.",
+		Long:  `Integrate bunnyexpress with Dovecot. Requires a subcommand.`,
+		RunE:  nil,
+	}
+
+	var dovecotConfigCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Write dovecot-sql.conf.ext for passdb and userdb",
+		Long: `Write dovecot-sql.conf.ext for the sqlite driver, with password_query, user_query 
+and iterate_query matching the mailbox table. 
+
+Inactive domains and mailboxes are excluded. Relative maildirs are placed below 
+dovecot.mail_base from be.config.json. An existing file is overwritten.`,
+		Args: cobra.NoArgs,
+		RunE: WriteDovecotConfig,
+	}
+	dovecotConfigCmd.Flags().StringP("dir", "d", ".", "directory to write dovecot-sql.conf.ext to")
+
+	RootCmd.AddCommand(dovecotCmd)
+
+	dovecotCmd.AddCommand(dovecotConfigCmd)
+}
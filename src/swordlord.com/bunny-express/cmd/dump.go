@@ -0,0 +1,159 @@
+package cmd
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"swordlord.com/bunny-express/db"
+)
+
+func DumpDatabase(cmd *cobra.Command, args []string) error {
+
+	d, err := db.GetDump()
+	if err != nil {
+		return fmt.Errorf("command 'dump' returns an error %s", err)
+	}
+
+	var out []byte
+
+	switch cmd.Flag("format").Value.String() {
+	case "json":
+		out, err = json.MarshalIndent(d, "", "  ")
+		out = append(out, '\n')
+	case "yaml":
+		out, err = yaml.Marshal(d)
+	default:
+		err = errors.New("unknown format, use json or yaml")
+	}
+
+	if err != nil {
+		return fmt.Errorf("command 'dump' returns an error %s", err)
+	}
+
+	_, err = os.Stdout.Write(out)
+	if err != nil {
+		return fmt.Errorf("command 'dump' returns an error %s", err)
+	}
+
+	return nil
+}
+
+func RestoreDatabase(cmd *cobra.Command, args []string) error {
+
+	merge, _ := cmd.Flags().GetBool("merge")
+	replace, _ := cmd.Flags().GetBool("replace")
+
+	mode := db.RestoreNew
+	switch {
+	case merge && replace:
+		return fmt.Errorf("command 'restore' returns an error %s", "use either --merge or --replace")
+	case merge:
+		mode = db.RestoreMerge
+	case replace:
+		mode = db.RestoreReplace
+	}
+
+	var in []byte
+	var err error
+
+	if args[0] == "-" {
+		in, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		in, err = ioutil.ReadFile(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("command 'restore' returns an error %s", err)
+	}
+
+	// JSON is YAML as well, but the json package knows the time format for sure
+	d := &db.Dump{}
+	if bytes.HasPrefix(bytes.TrimSpace(in), []byte("{")) {
+		err = json.Unmarshal(in, d)
+	} else {
+		err = yaml.Unmarshal(in, d)
+	}
+	if err != nil {
+		return fmt.Errorf("command 'restore' returns an error %s", err)
+	}
+
+	err = db.Restore(d, mode)
+	if err != nil {
+		return fmt.Errorf("command 'restore' returns an error %s", err)
+	}
+
+	fmt.Printf("Restored %d domain(s), %d mailbox(es), %d alias(es) and %d alias domain(s).\n", len(d.Domains), len(d.Mailboxen), len(d.Aliases), len(d.AliasDomains))
+
+	return nil
+}
+
+func init() {
+
+	var dumpCmd = &cobra.Command{
+		Use:   "dump",
+		Short: "Write all domains, mailboxes and aliases to stdout",
+		Long: `Write all domains, mailboxes and aliases to stdout, as JSON or YAML. Password 
+hashes and timestamps are included, keep the dump as safe as the database.
+
+Use 'be restore' to read it back.`,
+		Args:        cobra.NoArgs,
+		RunE:        DumpDatabase,
+		Annotations: map[string]string{annotationQuiet: "true"},
+	}
+	dumpCmd.Flags().StringP("format", "f", "json", "format of the dump, json or yaml")
+
+	var restoreCmd = &cobra.Command{
+		Use:   "restore [file]",
+		Short: "Restore domains, mailboxes and aliases from a dump",
+		Long: `Restore domains, mailboxes and aliases from a dump written by 'be dump', 
+use - to read from stdin. Everything is restored within one transaction, which is 
+only committed if all mailboxes and aliases have their domain.
+
+Without flags, the restore fails if any domain, mailbox or alias exists already. 
+With --merge, the rows of the dump overwrite existing ones and all others are kept. 
+With --replace, all domains, mailboxes and aliases are deleted first.`,
+		Args: cobra.ExactArgs(1),
+		RunE: RestoreDatabase,
+	}
+	restoreCmd.Flags().Bool("merge", false, "overwrite existing rows, keep the others")
+	restoreCmd.Flags().Bool("replace", false, "delete all domains, mailboxes and aliases first")
+
+	RootCmd.AddCommand(dumpCmd)
+	RootCmd.AddCommand(restoreCmd)
+}
@@ -0,0 +1,152 @@
+package cmd
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"swordlord.com/bunny-express/dovecot"
+	"swordlord.com/bunny-express/postfix"
+)
+
+func ExportPostfix(cmd *cobra.Command, args []string) error {
+
+	dir := cmd.Flag("dir").Value.String()
+
+	written, err := postfix.ExportMaps(dir)
+	if err != nil {
+		return fmt.Errorf("command 'postfix' returns an error %s", err)
+	}
+
+	for _, file := range written {
+		fmt.Printf("%s written.\n", file)
+	}
+
+	// maps written now are outdated, and the ones postmap failed on before
+	var outdated []string
+
+	if postfix.GetPostmapCommand() != "" {
+		outdated, err = postfix.GetOutdatedMaps(dir)
+		if err != nil {
+			return fmt.Errorf("command 'postfix' returns an error %s", err)
+		}
+	}
+
+	if len(written) == 0 && len(outdated) == 0 {
+		fmt.Println("All maps are up to date.")
+		return nil
+	}
+
+	err = postfix.RunPostmap(outdated)
+	if err != nil {
+		return fmt.Errorf("command 'postfix' returns an error %s", err)
+	}
+
+	if len(outdated) > 0 {
+		fmt.Printf("%s run on %d file(s).\n", postfix.GetPostmapCommand(), len(outdated))
+	}
+
+	return nil
+}
+
+func ExportDovecotPasswd(cmd *cobra.Command, args []string) error {
+
+	file := cmd.Flag("file").Value.String()
+
+	count, err := dovecot.ExportPasswdFile(file)
+	if err != nil {
+		return fmt.Errorf("command 'dovecot-passwd' returns an error %s", err)
+	}
+
+	fmt.Printf("%d user(s) written to %s.\n", count, file)
+
+	return nil
+}
+
+func init() {
+
+	var exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export domains, mailboxes and aliases for other tools.",
+		Long:  `Export domains, mailboxes and aliases for other tools. Requires a subcommand.`,
+		RunE:  nil,
+	}
+
+	var exportPostfixCmd = &cobra.Command{
+		Use:   "postfix",
+		Short: "Write lookup tables as postmap source files",
+		Long: `Write virtual_domains, virtual_mailboxes, virtual_aliases, sender_login_maps, 
+relay_domains and transport as postmap source files, for Postfix hosts without 
+access to the database. Only active domains, mailboxes and aliases are exported, 
+sorted by key. Files which did not change are not rewritten.
+
+If postfix.postmap_command is set in be.config.json, it is run for each file 
+written, and for each file whose compiled map is missing or older, e.g. 
+"postmap lmdb:%s". Use within main.cf as
+
+virtual_mailbox_domains = lmdb:/etc/postfix/virtual_domains
+virtual_mailbox_maps = lmdb:/etc/postfix/virtual_mailboxes
+virtual_alias_maps = lmdb:/etc/postfix/virtual_aliases
+smtpd_sender_login_maps = lmdb:/etc/postfix/sender_login_maps
+relay_domains = lmdb:/etc/postfix/relay_domains
+transport_maps = lmdb:/etc/postfix/transport`,
+		Args: cobra.NoArgs,
+		RunE: ExportPostfix,
+	}
+	exportPostfixCmd.Flags().StringP("dir", "d", ".", "directory to write the maps to")
+
+	var exportDovecotPasswdCmd = &cobra.Command{
+		Use:   "dovecot-passwd",
+		Short: "Write mailboxes as Dovecot passwd-file",
+		Long: `Write all active mailboxes of active domains as Dovecot passwd-file, one 
+user:{SCHEME}hash:uid:gid::home::extra fields line each. uid and gid are 
+dovecot.uid and dovecot.gid from be.config.json. Use within Dovecot as
+
+passdb {
+  driver = passwd-file
+  args = /etc/dovecot/users
+}
+userdb {
+  driver = passwd-file
+  args = /etc/dovecot/users
+}`,
+		Args: cobra.NoArgs,
+		RunE: ExportDovecotPasswd,
+	}
+	exportDovecotPasswdCmd.Flags().StringP("file", "f", "users", "passwd-file to write")
+
+	RootCmd.AddCommand(exportCmd)
+
+	exportCmd.AddCommand(exportPostfixCmd)
+	exportCmd.AddCommand(exportDovecotPasswdCmd)
+}
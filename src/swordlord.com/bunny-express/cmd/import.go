@@ -0,0 +1,208 @@
+package cmd
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"swordlord.com/bunny-express/importer"
+)
+
+func ImportDovecotPasswd(cmd *cobra.Command, args []string) error {
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("command 'dovecot-passwd' returns an error %s", err)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("command 'dovecot-passwd' returns an error %s", err)
+	}
+	defer f.Close()
+
+	report, err := importer.ImportDovecotPasswd(f, args[0], cmd.Flag("domain").Value.String(), dryRun)
+	if report != nil {
+		printImportReport(report)
+	}
+	if err != nil {
+		return fmt.Errorf("command 'dovecot-passwd' returns an error %s", err)
+	}
+
+	return nil
+}
+
+func ImportPostfixAdmin(cmd *cobra.Command, args []string) error {
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("command 'postfixadmin' returns an error %s", err)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("command 'postfixadmin' returns an error %s", err)
+	}
+	defer f.Close()
+
+	report, err := importer.ImportPostfixAdmin(f, args[0], dryRun)
+	if report != nil {
+		printImportReport(report)
+	}
+	if err != nil {
+		return fmt.Errorf("command 'postfixadmin' returns an error %s", err)
+	}
+
+	return nil
+}
+
+func ImportPostfixVirtual(cmd *cobra.Command, args []string) error {
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("command 'postfix-virtual' returns an error %s", err)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("command 'postfix-virtual' returns an error %s", err)
+	}
+	defer f.Close()
+
+	report, err := importer.ImportPostfixVirtual(f, args[0], cmd.Flag("type").Value.String(), dryRun)
+	if report != nil {
+		printImportReport(report)
+	}
+	if err != nil {
+		return fmt.Errorf("command 'postfix-virtual' returns an error %s", err)
+	}
+
+	return nil
+}
+
+func printImportReport(r *importer.Report) {
+
+	created := "Created"
+	if r.DryRun {
+		created = "Would create (dry run)"
+	}
+
+	sections := []struct {
+		title string
+		lines []string
+	}{
+		{created, r.Created},
+		{"Skipped", r.Skipped},
+		{"Conflicts, not imported", r.Conflicts},
+	}
+
+	for _, s := range sections {
+
+		fmt.Printf("%s: %d\n", s.title, len(s.lines))
+
+		for _, line := range s.lines {
+			fmt.Println("  " + line)
+		}
+	}
+}
+
+func addImportFlags(cmd *cobra.Command) {
+
+	cmd.Flags().Bool("dry-run", false, "only show what would be imported")
+}
+
+func init() {
+
+	var importCmd = &cobra.Command{
+		Use:   "import",
+		Short: "Import domains, mailboxes and aliases from other tools.",
+		Long:  `Import domains, mailboxes and aliases from other tools. Requires a subcommand.`,
+		RunE:  nil,
+	}
+
+	var importDovecotPasswdCmd = &cobra.Command{
+		Use:   "dovecot-passwd [file]",
+		Short: "Import mailboxes from a Dovecot passwd-file",
+		Long: `Import the users of a Dovecot passwd-file as mailboxes, missing domains are 
+created. Password hashes are kept as they are, crypt hashes without {SCHEME} prefix 
+are recognised. Homes below dovecot.mail_base become relative maildirs, the storage 
+limit of userdb_quota_rule becomes the quota. uid and gid are not imported.
+
+Existing mailboxes are not changed and listed as conflicts.`,
+		Args: cobra.ExactArgs(1),
+		RunE: ImportDovecotPasswd,
+	}
+	importDovecotPasswdCmd.Flags().StringP("domain", "d", "", "domain for users without @domain")
+	addImportFlags(importDovecotPasswdCmd)
+
+	var importPostfixAdminCmd = &cobra.Command{
+		Use:   "postfixadmin [dump.sql]",
+		Short: "Import domains, mailboxes and aliases from a PostfixAdmin SQL dump",
+		Long: `Import the domain, mailbox, alias and alias_domain tables of a PostfixAdmin 
+database, dumped with mysqldump or pg_dump. Password hashes are kept as they are, the 
+goto lists of aliases become forward addresses. The aliases PostfixAdmin keeps for 
+every mailbox are left out, forwards of mailboxes and vacations are not supported.
+
+Alias domains are expanded into one alias per mailbox and alias of the target domain.
+
+Existing domains, mailboxes and aliases are not changed and listed as conflicts.`,
+		Args: cobra.ExactArgs(1),
+		RunE: ImportPostfixAdmin,
+	}
+	addImportFlags(importPostfixAdminCmd)
+
+	var importPostfixVirtualCmd = &cobra.Command{
+		Use:   "postfix-virtual [file]",
+		Short: "Import aliases or mailboxes from a Postfix virtual map file",
+		Long: `Import a postmap source file, like /etc/postfix/virtual, missing domains are 
+created. With --type alias, the file is read as virtual_alias_maps: the targets of 
+a key become its forward addresses, @domain keys become catch-all aliases. With 
+--type mailbox, it is read as virtual_mailbox_maps: the maildirs, relative to 
+dovecot.mail_base, become mailboxes. These mailboxes get a random password, set 
+one with 'be mailbox edit', or import a passwd-file first.
+
+Keys without value or domain, mailbox forwards and mbox mailboxes are skipped. 
+Existing domains, mailboxes and aliases are not changed and listed as conflicts.`,
+		Args: cobra.ExactArgs(1),
+		RunE: ImportPostfixVirtual,
+	}
+	importPostfixVirtualCmd.Flags().StringP("type", "t", importer.PostfixVirtualAlias, "type of the map, alias or mailbox")
+	addImportFlags(importPostfixVirtualCmd)
+
+	RootCmd.AddCommand(importCmd)
+
+	importCmd.AddCommand(importDovecotPasswdCmd)
+	importCmd.AddCommand(importPostfixAdminCmd)
+	importCmd.AddCommand(importPostfixVirtualCmd)
+}
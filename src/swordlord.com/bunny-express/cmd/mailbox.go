@@ -37,8 +37,10 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"strconv"
+	"strings"
 	"swordlord.com/bunny-express/common"
 	"swordlord.com/bunny-express/db/mailbox"
+	"swordlord.com/bunny-express/db/usage"
 	"swordlord.com/bunny-express/util"
 )
 
@@ -68,9 +70,10 @@ func ListMailbox(cmd *cobra.Command, args []string) error {
 
 	for _, mb := range ms {
 
-		mailboxen = append(mailboxen, []string{mb.Mail, mb.Description.String, mb.Domain, mb.Password, mb.MailDir,
-			mb.LocalPart, mb.RelayDomain.String, mb.Quota.String,
-			strconv.FormatBool(mb.IsActive),
+		mailboxen = append(mailboxen, []string{mb.Mail, mb.Description.String, mb.Domain, mb.Password,
+			strings.Join(mb.GetAuthMechanisms(), " "), mb.MailDir,
+			mb.LocalPart, mb.RelayDomain.String, mb.Quota.String, formatSendLimits(&mb),
+			strconv.FormatBool(mb.IsActive), strconv.FormatBool(mb.IsSuspended),
 			mb.CrtDat.Format("2006-01-02 15:04:05"),
 			mb.UpdDat.Format("2006-01-02 15:04:05")})
 	}
@@ -87,15 +90,22 @@ func AddMailbox(cmd *cobra.Command, args []string) error {
 	m := mailbox.NewMailbox()
 
 	m.SetMail(args[0])
-	m.SetPassword(args[1], pwdScheme)
 	m.SetDomain(args[2])
 
+	err := setMailboxPassword(cmd, m, args[1], pwdScheme)
+	if err != nil {
+		return fmt.Errorf("command 'add' returns an error %s", err)
+	}
+
 	m.SetMailDir("")
 	m.SetLocalPart("")
 
 	m.SetQuota(0)
 
-	scanMailboxFlagsToObject(cmd, m)
+	err = scanMailboxFlagsToObject(cmd, m)
+	if err != nil {
+		return fmt.Errorf("command 'add' returns an error %s", err)
+	}
 
 	return m.Persist()
 }
@@ -107,12 +117,15 @@ func EditMailbox(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("command 'edit' returns an error %s", err)
 	}
 
-	scanMailboxFlagsToObject(cmd, m)
+	err = scanMailboxFlagsToObject(cmd, m)
+	if err != nil {
+		return fmt.Errorf("command 'edit' returns an error %s", err)
+	}
 
 	return m.Persist()
 }
 
-func scanMailboxFlagsToObject(cmd *cobra.Command, m *mailbox.Mailbox) {
+func scanMailboxFlagsToObject(cmd *cobra.Command, m *mailbox.Mailbox) error {
 
 	fActive := cmd.Flag("active")
 	if fActive.Changed {
@@ -123,6 +136,16 @@ func scanMailboxFlagsToObject(cmd *cobra.Command, m *mailbox.Mailbox) {
 		}
 	}
 
+	// check for nil since this flag is not used in all commands
+	fSuspended := cmd.Flag("suspended")
+	if fSuspended != nil && fSuspended.Changed {
+
+		b, err := strconv.ParseBool(fSuspended.Value.String())
+		if err == nil {
+			m.SetIsSuspended(b)
+		}
+	}
+
 	fDesc := cmd.Flag("description")
 	if fDesc.Changed {
 
@@ -139,7 +162,10 @@ func scanMailboxFlagsToObject(cmd *cobra.Command, m *mailbox.Mailbox) {
 
 		pwdScheme := checkSchemeFlag(cmd)
 
-		m.SetPassword(fPassword.Value.String(), pwdScheme)
+		err := setMailboxPassword(cmd, m, fPassword.Value.String(), pwdScheme)
+		if err != nil {
+			return err
+		}
 	}
 
 	fMaildir := cmd.Flag("maildir")
@@ -173,16 +199,71 @@ func scanMailboxFlagsToObject(cmd *cobra.Command, m *mailbox.Mailbox) {
 			m.SetQuotaAsNullString(s)
 		}
 	}
+
+	limits := []struct {
+		flag string
+		set  func(int)
+	}{
+		{"maxmsghour", m.SetMaxMsgHour},
+		{"maxmsgday", m.SetMaxMsgDay},
+		{"maxrcpthour", m.SetMaxRcptHour},
+		{"maxrcptday", m.SetMaxRcptDay},
+	}
+
+	for _, l := range limits {
+
+		fLimit := cmd.Flag(l.flag)
+		if fLimit.Changed {
+
+			max, err := strconv.Atoi(fLimit.Value.String())
+			if err != nil || max < 0 {
+				return fmt.Errorf("%s must be 0 or a positive number", l.flag)
+			}
+
+			l.set(max)
+		}
+	}
+
+	return nil
 }
 
-func checkSchemeFlag(cmd *cobra.Command) string {
+// limits as messages/recipients per hour and per day, - for no limit
+func formatSendLimits(m *mailbox.Mailbox) string {
 
-	pwdScheme := common.GetStringFromConfig("default.scheme")
-	if pwdScheme == "" {
+	limit := func(max int) string {
+		if max == 0 {
+			return "-"
+		}
+		return strconv.Itoa(max)
+	}
 
-		pwdScheme = "MD5-CRYPT"
+	if m.MaxMsgHour == 0 && m.MaxMsgDay == 0 && m.MaxRcptHour == 0 && m.MaxRcptDay == 0 {
+		return ""
 	}
 
+	return fmt.Sprintf("msg %s/h %s/d, rcpt %s/h %s/d", limit(m.MaxMsgHour), limit(m.MaxMsgDay),
+		limit(m.MaxRcptHour), limit(m.MaxRcptDay))
+}
+
+// with --hash the password is a {SCHEME}hash and taken as is, otherwise it gets hashed
+func setMailboxPassword(cmd *cobra.Command, m *mailbox.Mailbox, password string, pwdScheme string) error {
+
+	fHash := cmd.Flag("hash")
+	if fHash != nil && fHash.Changed {
+
+		isHash, err := strconv.ParseBool(fHash.Value.String())
+		if err == nil && isHash {
+			return m.SetPasswordHash(password)
+		}
+	}
+
+	return m.SetPassword(password, pwdScheme)
+}
+
+func checkSchemeFlag(cmd *cobra.Command) string {
+
+	pwdScheme := common.GetDefaultScheme()
+
 	fPwdScheme := cmd.Flag("pwdscheme")
 	if fPwdScheme != nil && fPwdScheme.Changed {
 
@@ -192,17 +273,95 @@ func checkSchemeFlag(cmd *cobra.Command) string {
 			pwdScheme = "MD5-CRYPT"
 		case "bcrypt":
 			pwdScheme = "BLF-CRYPT"
+		case "sha256crypt":
+			pwdScheme = "SHA256-CRYPT"
+		case "sha512crypt":
+			pwdScheme = "SHA512-CRYPT"
+		case "argon2i":
+			pwdScheme = "ARGON2I"
+		case "argon2id":
+			pwdScheme = "ARGON2ID"
+		case "cram-md5":
+			pwdScheme = "CRAM-MD5"
+		case "scram-sha-256":
+			pwdScheme = "SCRAM-SHA-256"
+		default:
+			// Dovecot names like SHA512-CRYPT, unknown ones are rejected by SetPassword
+			pwdScheme = strings.ToUpper(scheme)
 		}
 	}
 
 	return pwdScheme
 }
 
+func CheckMailboxPassword(cmd *cobra.Command, args []string) error {
+
+	m, err := mailbox.GetMailbox(args[0])
+	if err != nil {
+		return fmt.Errorf("command 'checkpw' returns an error %s", err)
+	}
+
+	pwd, err := util.ReadPassword("Password: ")
+	if err != nil {
+		return fmt.Errorf("command 'checkpw' returns an error %s", err)
+	}
+
+	err = m.VerifyPassword(pwd)
+	if err != nil {
+		return fmt.Errorf("command 'checkpw' returns an error %s", err)
+	}
+
+	fmt.Println("Password matches.")
+
+	return nil
+}
+
+func ListMailboxUsage(cmd *cobra.Command, args []string) error {
+
+	filter := ""
+	if len(args) > 0 {
+		filter = args[0]
+	}
+
+	us, err := usage.GetFilteredUsage(filter)
+	if err != nil {
+		return fmt.Errorf("command 'usage' returns an error %s", err)
+	}
+
+	var rows [][]string
+
+	for _, u := range us {
+
+		quota := ""
+		m, err := mailbox.GetMailbox(u.Mail)
+		if err == nil {
+			quota = m.Quota.String
+		}
+
+		rows = append(rows, []string{u.Mail, quota,
+			strconv.FormatInt(u.Storage, 10),
+			strconv.FormatInt(u.Messages, 10),
+			u.UpdDat.Format("2006-01-02 15:04:05")})
+	}
+
+	util.WriteTable(usage.GetFieldCaptions(), rows)
+
+	return nil
+}
+
 func DeleteMailbox(cmd *cobra.Command, args []string) error {
 
 	return mailbox.DeleteMailbox(args[0])
 }
 
+func addSendLimitFlags(cmd *cobra.Command) {
+
+	cmd.Flags().Int("maxmsghour", 0, "max messages sent per hour, 0 for no limit")
+	cmd.Flags().Int("maxmsgday", 0, "max messages sent per day, 0 for no limit")
+	cmd.Flags().Int("maxrcpthour", 0, "max recipients per hour, 0 for no limit")
+	cmd.Flags().Int("maxrcptday", 0, "max recipients per day, 0 for no limit")
+}
+
 func init() {
 
 	// calCmd represents the domain command
@@ -225,9 +384,12 @@ func init() {
 	var mailboxAddCmd = &cobra.Command{
 		Use:   "add [mailbox] [password] [domain]",
 		Short: "Add new mailbox to given domain",
-		Long:  `Add new mailbox with parameters given and add it to the given domain.`,
-		Args:  cobra.ExactArgs(3),
-		RunE:  AddMailbox,
+		Long: `Add new mailbox with parameters given and add it to the given domain.
+
+With --hash the password is taken as an already hashed {SCHEME}hash, e.g. when 
+migrating accounts from another server. Malformed hashes are rejected.`,
+		Args: cobra.ExactArgs(3),
+		RunE: AddMailbox,
 	}
 	mailboxAddCmd.Flags().BoolP("active", "a", true, "is mailbox active")
 	mailboxAddCmd.Flags().StringP("description", "d", "", "description for this mailbox")
@@ -235,23 +397,31 @@ func init() {
 	mailboxAddCmd.Flags().StringP("localpart", "l", "", "local part, better not change this")
 	mailboxAddCmd.Flags().StringP("relaydomain", "r", "", "relay domain")
 	mailboxAddCmd.Flags().StringP("quota", "q", "", "quota for this user")
-	mailboxAddCmd.Flags().StringP("pwdscheme", "s", "", "password hashing scheme to be used")
+	mailboxAddCmd.Flags().StringP("pwdscheme", "s", "", "password hashing scheme to be used (md5crypt, bcrypt, sha256crypt, sha512crypt, argon2i, argon2id, cram-md5, scram-sha-256)")
+	mailboxAddCmd.Flags().Bool("hash", false, "password is already hashed, given as {SCHEME}hash")
+	addSendLimitFlags(mailboxAddCmd)
 
 	var mailboxEditCmd = &cobra.Command{
 		Use:   "edit [mailbox]",
 		Short: "Edit an existing mailbox",
-		Long:  `Edit.`,
-		Args:  cobra.ExactArgs(1),
-		RunE:  EditMailbox,
+		Long: `Edit an existing mailbox. Only the values of the flags given are changed.
+
+Send limits are enforced by 'be serve policy' for mail sent by authenticated users. 
+Use --suspended=false to lift a suspension by 'be serve authpolicy'.`,
+		Args: cobra.ExactArgs(1),
+		RunE: EditMailbox,
 	}
 	mailboxEditCmd.Flags().BoolP("active", "a", true, "is mailbox active")
+	mailboxEditCmd.Flags().Bool("suspended", false, "is mailbox suspended after failed logins")
 	mailboxEditCmd.Flags().StringP("description", "d", "", "description for this mailbox")
 	mailboxEditCmd.Flags().StringP("password", "p", "", "password in clear")
 	mailboxEditCmd.Flags().StringP("maildir", "m", "", "maildir to be used")
 	mailboxEditCmd.Flags().StringP("localpart", "l", "", "local part, better not change this")
 	mailboxEditCmd.Flags().StringP("relaydomain", "r", "", "relay domain")
 	mailboxEditCmd.Flags().StringP("quota", "q", "", "quota for this user")
-	mailboxEditCmd.Flags().StringP("pwdscheme", "s", "", "password hashing scheme to be used")
+	mailboxEditCmd.Flags().StringP("pwdscheme", "s", "", "password hashing scheme to be used (md5crypt, bcrypt, sha256crypt, sha512crypt, argon2i, argon2id, cram-md5, scram-sha-256)")
+	mailboxEditCmd.Flags().Bool("hash", false, "password is already hashed, given as {SCHEME}hash")
+	addSendLimitFlags(mailboxEditCmd)
 
 	var mailboxDeleteCmd = &cobra.Command{
 		Use:   "delete [mailbox]",
@@ -261,10 +431,33 @@ func init() {
 		RunE:  DeleteMailbox,
 	}
 
+	var mailboxCheckPwCmd = &cobra.Command{
+		Use:   "checkpw [mailbox]",
+		Short: "Check a password against the stored hash",
+		Long: `Check a password against the stored hash of the given mailbox. The password is 
+read from the terminal. Works with all supported password schemes. 
+
+Like any successful login, a match rehashes passwords stored with a scheme weaker 
+than default.scheme, unless auth.rehash is false.`,
+		Args: cobra.ExactArgs(1),
+		RunE: CheckMailboxPassword,
+	}
+
+	var mailboxUsageCmd = &cobra.Command{
+		Use:   "usage [mailbox]",
+		Short: "Show quota usage of mailboxes",
+		Long: `Show storage in bytes and number of messages of mailboxes, as reported by Dovecot 
+through 'be serve dict'. The mailbox can have wildcards, all are shown without.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: ListMailboxUsage,
+	}
+
 	RootCmd.AddCommand(mailboxCmd)
 
 	mailboxCmd.AddCommand(mailboxListCmd)
 	mailboxCmd.AddCommand(mailboxAddCmd)
 	mailboxCmd.AddCommand(mailboxEditCmd)
 	mailboxCmd.AddCommand(mailboxDeleteCmd)
+	mailboxCmd.AddCommand(mailboxCheckPwCmd)
+	mailboxCmd.AddCommand(mailboxUsageCmd)
 }
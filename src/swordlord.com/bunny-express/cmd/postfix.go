@@ -0,0 +1,85 @@
+package cmd
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"swordlord.com/bunny-express/postfix"
+)
+
+func WritePostfixConfig(cmd *cobra.Command, args []string) error {
+
+	dir := cmd.Flag("dir").Value.String()
+
+	mainCf, err := postfix.WriteLookupConfigs(dir)
+	if err != nil {
+		return fmt.Errorf("command 'config' returns an error %s", err)
+	}
+
+	fmt.Println("Lookup tables written. Add these lines to your main.cf:")
+	fmt.Println("")
+
+	for _, line := range mainCf {
+		fmt.Println(line)
+	}
+
+	return nil
+}
+
+func init() {
+
+	var postfixCmd = &cobra.Command{
+		Use:   "postfix",
+		Short: "Integrate bunnyexpress with Postfix.",
+		Long:  `Integrate bunnyexpress with Postfix. Requires a subcommand.`,
+		RunE:  nil,
+	}
+
+	var postfixConfigCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Write sqlite lookup table configs for Postfix",
+		Long: `Write sqlite lookup table configs for virtual_mailbox_domains, virtual_mailbox_maps, 
+virtual_alias_maps, smtpd_sender_login_maps, relay_domains and transport_maps. 
+
+The configs point to the database file configured in be.config.json and only return 
+active domains, mailboxes and aliases. Existing files are overwritten.`,
+		Args: cobra.NoArgs,
+		RunE: WritePostfixConfig,
+	}
+	postfixConfigCmd.Flags().StringP("dir", "d", ".", "directory to write the lookup table configs to")
+
+	RootCmd.AddCommand(postfixCmd)
+
+	postfixCmd.AddCommand(postfixConfigCmd)
+}
@@ -38,6 +38,12 @@ import (
 
 // var cfgFile string // see init() for details
 
+// set on commands run by other programs (Dovecot, Postfix), no banner, logs go to stderr
+const annotationQuiet = "quiet"
+
+// set on commands which work while schema migrations are pending
+const annotationOutdatedSchema = "outdated_schema"
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:           "be",
@@ -51,6 +57,32 @@ With bunnyexpress you can manage domains, mailboxes and aliases for your own mai
 Everything is stored within an SQLite3 database. See accompanied ReadMe and help for more details.`,
 }
 
+// IsQuiet tells if the command called with given args wants stdout for itself.
+func IsQuiet(args []string) bool {
+
+	c, _, err := RootCmd.Find(args)
+
+	return err == nil && c.Annotations[annotationQuiet] == "true"
+}
+
+// RunsOnOutdatedSchema tells if the command called with given args may run while schema
+// migrations are pending. Only help and the commands showing and applying them may.
+func RunsOnOutdatedSchema(args []string) bool {
+
+	c, _, err := RootCmd.Find(args)
+	if err != nil || !c.Runnable() || c.Name() == "help" || c.Annotations[annotationOutdatedSchema] == "true" {
+		return true
+	}
+
+	for _, arg := range args {
+		if arg == "-h" || arg == "--help" {
+			return true
+		}
+	}
+
+	return false
+}
+
 func init() {
 
 	// following lines just for reference.
@@ -0,0 +1,170 @@
+package cmd
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/spf13/cobra"
+	"strconv"
+	"swordlord.com/bunny-express/db/authfailure"
+	"swordlord.com/bunny-express/db/mailbox"
+	"swordlord.com/bunny-express/dovecot"
+	"swordlord.com/bunny-express/util"
+)
+
+func ListLockouts(cmd *cobra.Command, args []string) error {
+
+	afs, err := authfailure.GetAuthFailures(dovecot.GetAuthPolicyWindow())
+	if err != nil {
+		return fmt.Errorf("command 'list' returns an error %s", err)
+	}
+
+	var lockouts [][]string
+
+	for _, af := range afs {
+
+		state := ""
+		status := dovecot.GetAuthPolicyStatus(af.Failures)
+		if status == dovecot.AuthPolicyReject {
+			state = "rejected"
+		} else if status > 0 {
+			state = "delayed " + strconv.Itoa(status) + "s"
+		}
+
+		lockouts = append(lockouts, []string{af.Kind, af.Name, strconv.Itoa(af.Failures),
+			af.CrtDat.Local().Format("2006-01-02 15:04:05"),
+			af.UpdDat.Local().Format("2006-01-02 15:04:05"),
+			state})
+	}
+
+	util.WriteTable(authfailure.GetFieldCaptions(), lockouts)
+
+	return nil
+}
+
+func ClearLockouts(cmd *cobra.Command, args []string) error {
+
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return fmt.Errorf("command 'clear' returns an error %s", err)
+	}
+
+	if all {
+
+		count, err := authfailure.ClearAllFailures()
+		if err != nil {
+			return fmt.Errorf("command 'clear' returns an error %s", err)
+		}
+
+		fmt.Printf("%d lockout(s) cleared.\n", count)
+
+		return nil
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("command 'clear' needs a login or IP, or --all")
+	}
+
+	count, err := authfailure.ClearFailures("", args[0])
+	if err != nil {
+		return fmt.Errorf("command 'clear' returns an error %s", err)
+	}
+
+	fmt.Printf("%d lockout(s) cleared.\n", count)
+
+	// a cleared login gets its mailbox back
+	m, err := mailbox.GetMailbox(args[0])
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("command 'clear' returns an error %s", err)
+	}
+
+	if m.IsSuspended {
+
+		m.SetIsSuspended(false)
+
+		err = m.Persist()
+		if err != nil {
+			return fmt.Errorf("command 'clear' returns an error %s", err)
+		}
+
+		fmt.Printf("Mailbox %s no longer suspended.\n", m.Mail)
+	}
+
+	return nil
+}
+
+func init() {
+
+	var securityCmd = &cobra.Command{
+		Use:   "security",
+		Short: "Manage brute-force protection.",
+		Long:  `Manage brute-force protection. Requires a subcommand.`,
+		RunE:  nil,
+	}
+
+	var securityLockoutsCmd = &cobra.Command{
+		Use:   "lockouts",
+		Short: "List and clear failed logins counted by the auth policy server.",
+		Long:  `List and clear failed logins counted by the auth policy server. Requires a subcommand.`,
+		RunE:  nil,
+	}
+
+	var securityLockoutsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List logins and IPs with failed logins",
+		Long: `List logins and remote IPs with failed logins within authpolicy.window, and if 
+further logins are delayed or rejected.`,
+		Args: cobra.NoArgs,
+		RunE: ListLockouts,
+	}
+
+	var securityLockoutsClearCmd = &cobra.Command{
+		Use:   "clear [login or IP]",
+		Short: "Clear failed logins of a login or IP",
+		Long: `Clear failed logins of the given login or remote IP, or of all with --all. A mailbox 
+suspended after failed logins is reactivated when its login is cleared.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: ClearLockouts,
+	}
+	securityLockoutsClearCmd.Flags().Bool("all", false, "clear all failed logins")
+
+	RootCmd.AddCommand(securityCmd)
+
+	securityCmd.AddCommand(securityLockoutsCmd)
+
+	securityLockoutsCmd.AddCommand(securityLockoutsListCmd)
+	securityLockoutsCmd.AddCommand(securityLockoutsClearCmd)
+}
@@ -0,0 +1,346 @@
+package cmd
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"strings"
+	"swordlord.com/bunny-express/dovecot"
+	"swordlord.com/bunny-express/postfix"
+	"swordlord.com/bunny-express/util"
+)
+
+func ServeSocketmap(cmd *cobra.Command, args []string) error {
+
+	listen, mode, err := getServeFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("command 'socketmap' returns an error %s", err)
+	}
+
+	server := util.NewServer()
+
+	for _, address := range listen {
+
+		_, err = server.Listen(address, mode, postfix.HandleSocketmap)
+		if err != nil {
+			return fmt.Errorf("command 'socketmap' returns an error %s", err)
+		}
+	}
+
+	server.Serve()
+
+	return nil
+}
+
+func ServeTcpTable(cmd *cobra.Command, args []string) error {
+
+	listen, mode, err := getServeFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("command 'tcptable' returns an error %s", err)
+	}
+
+	server := util.NewServer()
+
+	for _, entry := range listen {
+
+		// address=map, the address may contain = only when it is a unix socket path
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return fmt.Errorf("command 'tcptable' returns an error, %s does not name a map, use address=map", entry)
+		}
+
+		address, name := entry[:i], entry[i+1:]
+		if !postfix.IsMap(name) {
+			return fmt.Errorf("command 'tcptable' returns an error, unknown map %s", name)
+		}
+
+		_, err = server.Listen(address, mode, postfix.NewTcpTableHandler(name))
+		if err != nil {
+			return fmt.Errorf("command 'tcptable' returns an error %s", err)
+		}
+	}
+
+	server.Serve()
+
+	return nil
+}
+
+func ServePolicy(cmd *cobra.Command, args []string) error {
+
+	listen, mode, err := getServeFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("command 'policy' returns an error %s", err)
+	}
+
+	server := util.NewServer()
+	policy := postfix.NewPolicyServer()
+
+	for _, address := range listen {
+
+		_, err = server.Listen(address, mode, policy.Handle)
+		if err != nil {
+			return fmt.Errorf("command 'policy' returns an error %s", err)
+		}
+	}
+
+	server.Serve()
+
+	return nil
+}
+
+func ServeDict(cmd *cobra.Command, args []string) error {
+
+	listen, mode, err := getServeFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("command 'dict' returns an error %s", err)
+	}
+
+	server := util.NewServer()
+
+	for _, address := range listen {
+
+		_, err = server.Listen(address, mode, dovecot.HandleDict)
+		if err != nil {
+			return fmt.Errorf("command 'dict' returns an error %s", err)
+		}
+	}
+
+	server.Serve()
+
+	return nil
+}
+
+func ServeAuthPolicy(cmd *cobra.Command, args []string) error {
+
+	listen, mode, err := getServeFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("command 'authpolicy' returns an error %s", err)
+	}
+
+	server := util.NewServer()
+	handler := dovecot.NewAuthPolicyHandler()
+
+	for _, address := range listen {
+
+		_, err = server.ListenHTTP(address, mode, handler)
+		if err != nil {
+			return fmt.Errorf("command 'authpolicy' returns an error %s", err)
+		}
+	}
+
+	server.Serve()
+
+	return nil
+}
+
+// returns the --listen addresses and the --socketmode
+func getServeFlags(cmd *cobra.Command) ([]string, os.FileMode, error) {
+
+	listen, err := cmd.Flags().GetStringSlice("listen")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(listen) == 0 {
+		return nil, 0, fmt.Errorf("no address to listen on, use --listen")
+	}
+
+	mode, err := strconv.ParseUint(cmd.Flag("socketmode").Value.String(), 8, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf("socketmode must be an octal file mode like 0660")
+	}
+
+	return listen, os.FileMode(mode), nil
+}
+
+func addServeFlags(cmd *cobra.Command) {
+
+	cmd.Flags().StringSliceP("listen", "l", []string{}, "address to listen on, unix:/path/to/socket or tcp:host:port, can be repeated")
+	cmd.Flags().String("socketmode", "0660", "file mode of unix sockets")
+}
+
+func init() {
+
+	var serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Serve lookups to Postfix and Dovecot.",
+		Long:  `Serve lookups to Postfix and Dovecot. Requires a subcommand.`,
+		RunE:  nil,
+	}
+
+	var serveSocketmapCmd = &cobra.Command{
+		Use:   "socketmap",
+		Short: "Serve Postfix lookup tables with the socketmap protocol",
+		Long: `Serve Postfix lookup tables with the socketmap protocol, so that Postfix does not 
+need access to the database file. Use within main.cf as
+
+virtual_mailbox_domains = socketmap:unix:/run/be/socketmap.sock:virtual_mailbox_domains
+virtual_mailbox_maps = socketmap:unix:/run/be/socketmap.sock:virtual_mailbox_maps
+virtual_alias_maps = socketmap:unix:/run/be/socketmap.sock:virtual_alias_maps
+smtpd_sender_login_maps = socketmap:unix:/run/be/socketmap.sock:smtpd_sender_login_maps
+relay_domains = socketmap:unix:/run/be/socketmap.sock:relay_domains
+transport_maps = socketmap:unix:/run/be/socketmap.sock:transport_maps
+
+Mailboxes resolve to themselves in virtual_alias_maps, other addresses to the 
+forwards of their alias or of the catch-all alias of their domain. Runs until 
+SIGTERM or SIGINT.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationQuiet: "true"},
+		RunE:        ServeSocketmap,
+	}
+	addServeFlags(serveSocketmapCmd)
+
+	var serveTcpTableCmd = &cobra.Command{
+		Use:   "tcptable",
+		Short: "Serve Postfix lookup tables with the tcp_table protocol",
+		Long: `Serve Postfix lookup tables with the tcp_table protocol, for setups without 
+socketmap support. The protocol has no map name, every --listen address serves 
+one map given as address=map. Use within main.cf as
+
+virtual_mailbox_domains = tcp:127.0.0.1:10001
+virtual_mailbox_maps = tcp:127.0.0.1:10002
+virtual_alias_maps = tcp:127.0.0.1:10003
+
+with
+
+be serve tcptable --listen 127.0.0.1:10001=virtual_mailbox_domains \
+  --listen 127.0.0.1:10002=virtual_mailbox_maps \
+  --listen 127.0.0.1:10003=virtual_alias_maps
+
+Lookups resolve the same way as with socketmap. Runs until SIGTERM or SIGINT.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationQuiet: "true"},
+		RunE:        ServeTcpTable,
+	}
+	addServeFlags(serveTcpTableCmd)
+
+	var servePolicyCmd = &cobra.Command{
+		Use:   "policy",
+		Short: "Enforce send limits of mailboxes as Postfix policy server",
+		Long: `Enforce send limits of mailboxes as Postfix policy server. Limits are set with 
+'be mailbox edit', e.g. --maxmsghour and --maxrcptday, and apply to clients 
+authenticated with SASL. Use within main.cf as
+
+smtpd_recipient_restrictions = 
+  check_policy_service inet:127.0.0.1:10040, ...
+smtpd_end_of_data_restrictions = 
+  check_policy_service inet:127.0.0.1:10040
+
+with
+
+be serve policy --listen 127.0.0.1:10040
+
+Recipients over the limit are deferred, logins of inactive mailboxes rejected, 
+everything else gets DUNNO. Sent messages are counted in the database once their 
+data was accepted. Runs until SIGTERM or SIGINT.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationQuiet: "true"},
+		RunE:        ServePolicy,
+	}
+	addServeFlags(servePolicyCmd)
+
+	var serveDictCmd = &cobra.Command{
+		Use:   "dict",
+		Short: "Serve passdb, userdb and quota to Dovecot with the dict protocol",
+		Long: `Serve passdb, userdb and quota to Dovecot with the dict proxy protocol. Lookups of 
+shared/passdb/<user> and shared/userdb/<user> are answered with JSON from the 
+mailbox table, inactive domains and mailboxes are not found. Updates of 
+priv/quota/storage and priv/quota/messages are stored, see 'be mailbox usage'.
+
+be serve dict --listen unix:/run/be/dict.sock
+
+Use within Dovecot as
+
+passdb {
+  driver = dict
+  args = /etc/dovecot/dovecot-dict-auth.conf.ext
+}
+userdb {
+  driver = dict
+  args = /etc/dovecot/dovecot-dict-auth.conf.ext
+}
+plugin {
+  quota = dict:User quota::proxy:/run/be/dict.sock:quota
+}
+
+with dovecot-dict-auth.conf.ext containing
+
+uri = proxy:/run/be/dict.sock:auth
+password_key = passdb/%u
+user_key = userdb/%u
+iterate_disable = no
+iterate_prefix = userdb/
+
+Runs until SIGTERM or SIGINT.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationQuiet: "true"},
+		RunE:        ServeDict,
+	}
+	addServeFlags(serveDictCmd)
+
+	var serveAuthPolicyCmd = &cobra.Command{
+		Use:   "authpolicy",
+		Short: "Slow down and block brute-force logins as Dovecot auth policy server",
+		Long: `Slow down and block brute-force logins as Dovecot auth policy server. Failed logins 
+are counted per login and per remote IP. After authpolicy.free_failures the login 
+is delayed, doubling up to authpolicy.delay_max seconds, after 
+authpolicy.reject_after it is rejected. Failures older than authpolicy.window 
+seconds are forgotten. Mailboxes with authpolicy.suspend_after failed logins are 
+suspended until cleared with 'be security lockouts clear'.
+
+be serve authpolicy --listen 127.0.0.1:4001
+
+Use within Dovecot as
+
+auth_policy_server_url = http://127.0.0.1:4001/
+auth_policy_request_attributes = login=%{requested_username} remote=%{rip} protocol=%s
+
+See 'be security lockouts list' for the current failures. Runs until SIGTERM or 
+SIGINT.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationQuiet: "true"},
+		RunE:        ServeAuthPolicy,
+	}
+	addServeFlags(serveAuthPolicyCmd)
+
+	RootCmd.AddCommand(serveCmd)
+
+	serveCmd.AddCommand(serveSocketmapCmd)
+	serveCmd.AddCommand(serveTcpTableCmd)
+	serveCmd.AddCommand(servePolicyCmd)
+	serveCmd.AddCommand(serveDictCmd)
+	serveCmd.AddCommand(serveAuthPolicyCmd)
+}
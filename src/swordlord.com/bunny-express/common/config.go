@@ -79,6 +79,15 @@ func GetIntFromConfig(key string) int {
 	return viper.GetInt(key)
 }
 
+func GetIntFromConfigWDefault(key string, def int) int {
+
+	if !viper.IsSet(key) {
+		return def
+	} else {
+		return viper.GetInt(key)
+	}
+}
+
 // values need to be separated by empty char ( )
 func GetStringSliceFromConfig(key string) []string {
 	return viper.GetStringSlice(key)
@@ -105,6 +114,18 @@ func GetLogLevel() string {
 	}
 }
 
+func GetDefaultScheme() string {
+
+	scheme := viper.GetString("default.scheme")
+	if scheme == "" {
+
+		return "MD5-CRYPT"
+	} else {
+
+		return scheme
+	}
+}
+
 //
 func writeStandardConfig() error {
 
@@ -127,6 +148,29 @@ var defaultConfig = []byte(`
     "default": {
     "alias": "info abuse",
     "scheme": "MD5-CRYPT"
+  },
+  "auth": {
+    "rehash": true
+  },
+  "hash": {
+    "sha_crypt_rounds": 5000,
+    "argon2_memory": 65536,
+    "argon2_time": 3
+  },
+  "postfix": {
+    "postmap_command": ""
+  },
+  "dovecot": {
+    "mail_base": "/var/mail/vhosts",
+    "uid": "",
+    "gid": ""
+  },
+  "authpolicy": {
+    "window": 3600,
+    "free_failures": 3,
+    "delay_max": 30,
+    "reject_after": 20,
+    "suspend_after": 50
   }
 }
 `)
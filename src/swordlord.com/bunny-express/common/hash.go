@@ -41,18 +41,79 @@ package common
  **   * can do whatever you want with this stuff. If we meet some day, and you think
  **   * this stuff is worth it, you can buy me a beer in return.   Poul-Henning Kamp
  **
+ ** The SHA-Crypt code follows the specification by Ulrich Drepper at
+ ** https://www.akkadia.org/drepper/SHA-crypt.txt (public domain)
+ **
 -----------------------------------------------------------------------------*/
 
 import (
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"strconv"
+	"strings"
 )
 
 const p64alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 
+// returned by all Check functions when the hash is fine but the password is not
+var ErrPasswordMismatch = errors.New("password does not match")
+
+const (
+	shaCryptRoundsDefault = 5000
+	shaCryptRoundsMin     = 1000
+	shaCryptRoundsMax     = 999999999 // glibc limit, only for hash.sha_crypt_rounds
+	// stored hashes above are refused, they would take the server down
+	shaCryptRoundsStoredMax = 1000000
+	shaCryptSaltMax         = 16
+	sha256CryptHashLen      = 43
+	sha512CryptHashLen      = 86
+
+	// libsodium, which Dovecot uses, only supports a single thread
+	argon2Threads       = 1
+	argon2SaltBytes     = 16
+	argon2KeyBytes      = 32
+	argon2MemoryDefault = 65536 // KiB
+	argon2TimeDefault   = 3
+	// stored hashes above are refused, they would take the server down
+	argon2MemoryMax = 4194304 // KiB
+	argon2TimeMax   = 1000
+
+	// same defaults and limit as Dovecot
+	scramIterations    = 4096
+	scramIterationsMax = 128 * 4096
+	scramSaltBytes     = 16
+)
+
 var md5permute [5][3]int
 
+// byte order used when encoding the final SHA-Crypt digests, see specification
+var sha256permute = [][3]int{
+	{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+	{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+}
+
+var sha512permute = [][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+	{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+	{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+	{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+	{62, 20, 41},
+}
+
 func md5Init() {
 	md5permute = [5][3]int{
 		[3]int{0, 6, 12},
@@ -98,7 +159,304 @@ func CheckHashedPasswordBCrypt(hashedPassword string, password string) error {
 func CheckHashedPasswordMD5Crypt(hashedPasswordWSalt string, password string) error {
 	md5Init()
 
-	return errors.New("")
+	salt, err := parseMD5Crypt(hashedPasswordWSalt)
+	if err != nil {
+		return err
+	}
+
+	hash, err := md5Crypt([]byte(password), []byte(salt))
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(hash, []byte(hashedPasswordWSalt)) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+// rounds are taken from hash.sha_crypt_rounds if not given (0)
+func HashPasswordSHA256Crypt(pwd string, salt string, rounds int) (string, error) {
+
+	return shaCrypt(sha256.New, "$5$", []byte(pwd), salt, rounds, rounds != 0)
+}
+
+// rounds are taken from hash.sha_crypt_rounds if not given (0)
+func HashPasswordSHA512Crypt(pwd string, salt string, rounds int) (string, error) {
+
+	return shaCrypt(sha512.New, "$6$", []byte(pwd), salt, rounds, rounds != 0)
+}
+
+func CheckHashedPasswordSHA256Crypt(hashedPassword string, password string) error {
+
+	return checkShaCrypt(sha256.New, "$5$", sha256CryptHashLen, hashedPassword, password)
+}
+
+func CheckHashedPasswordSHA512Crypt(hashedPassword string, password string) error {
+
+	return checkShaCrypt(sha512.New, "$6$", sha512CryptHashLen, hashedPassword, password)
+}
+
+// memory (KiB) and time are taken from hash.argon2_memory and hash.argon2_time if not given (0)
+func HashPasswordArgon2I(pwd string, memory uint32, time uint32) (string, error) {
+
+	return argon2Hash("argon2i", []byte(pwd), memory, time)
+}
+
+// memory (KiB) and time are taken from hash.argon2_memory and hash.argon2_time if not given (0)
+func HashPasswordArgon2ID(pwd string, memory uint32, time uint32) (string, error) {
+
+	return argon2Hash("argon2id", []byte(pwd), memory, time)
+}
+
+func CheckHashedPasswordArgon2I(hashedPassword string, password string) error {
+
+	return checkArgon2("argon2i", hashedPassword, password)
+}
+
+func CheckHashedPasswordArgon2ID(hashedPassword string, password string) error {
+
+	return checkArgon2("argon2id", hashedPassword, password)
+}
+
+// CRAM-MD5 stores the HMAC-MD5 context instead of a hash, see Dovecot's hmac-cram-md5.c
+func HashPasswordCramMD5(pwd string) (string, error) {
+
+	return hex.EncodeToString(cramMD5Context([]byte(pwd))), nil
+}
+
+func CheckHashedPasswordCramMD5(hashedPassword string, password string) error {
+
+	stored, err := parseCramMD5(hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(cramMD5Context([]byte(password)), stored) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+// Dovecot's format: iterations,salt,StoredKey,ServerKey (RFC 5802, base64 encoded)
+func HashPasswordScramSHA256(pwd string) (string, error) {
+
+	salt := make([]byte, scramSaltBytes)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	storedKey, serverKey := scramKeys([]byte(pwd), salt, scramIterations)
+
+	return fmt.Sprintf("%d,%s,%s,%s", scramIterations, base64.StdEncoding.EncodeToString(salt),
+		base64.StdEncoding.EncodeToString(storedKey), base64.StdEncoding.EncodeToString(serverKey)), nil
+}
+
+func CheckHashedPasswordScramSHA256(hashedPassword string, password string) error {
+
+	iterations, salt, storedKey, serverKey, err := parseScramSHA256(hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	sk, srvk := scramKeys([]byte(password), salt, iterations)
+
+	if subtle.ConstantTimeCompare(sk, storedKey)&subtle.ConstantTimeCompare(srvk, serverKey) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+// GetAuthMechanisms returns the SASL mechanisms Dovecot can offer for a password stored
+// with the given scheme. Challenge-response needs the matching credentials.
+func GetAuthMechanisms(scheme string) []string {
+
+	mechanisms := []string{"PLAIN", "LOGIN"}
+
+	switch scheme {
+	case "CRAM-MD5":
+		mechanisms = append(mechanisms, "CRAM-MD5")
+	case "SCRAM-SHA-256":
+		mechanisms = append(mechanisms, "SCRAM-SHA-256")
+	}
+
+	return mechanisms
+}
+
+// GenerateSalt returns a random salt of given length, made of crypt's base64 alphabet.
+func GenerateSalt(length int) (string, error) {
+
+	b := make([]byte, length)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range b {
+		b[i] = p64alphabet[int(b[i])%len(p64alphabet)]
+	}
+
+	return string(b), nil
+}
+
+// SplitSchemeAndHash splits a password as stored in mailbox.pwd into scheme and hash.
+func SplitSchemeAndHash(stored string) (string, string, error) {
+
+	if !strings.HasPrefix(stored, "{") {
+		return "", "", errors.New("password has no {SCHEME} prefix")
+	}
+
+	end := strings.Index(stored, "}")
+	if end < 2 {
+		return "", "", errors.New("password has no valid {SCHEME} prefix")
+	}
+
+	return strings.ToUpper(stored[1:end]), stored[end+1:], nil
+}
+
+// DetectScheme returns the scheme of a crypt(3) style hash without {SCHEME} prefix, as
+// found in passwd files and other tools' databases. Empty if not recognised.
+func DetectScheme(hash string) string {
+
+	switch {
+	case strings.HasPrefix(hash, "$1$"):
+		return "MD5-CRYPT"
+	case strings.HasPrefix(hash, "$5$"):
+		return "SHA256-CRYPT"
+	case strings.HasPrefix(hash, "$6$"):
+		return "SHA512-CRYPT"
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return "BLF-CRYPT"
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return "ARGON2ID"
+	case strings.HasPrefix(hash, "$argon2i$"):
+		return "ARGON2I"
+	default:
+		return ""
+	}
+}
+
+// CheckPassword verifies a password against a {SCHEME}hash as stored in mailbox.pwd.
+// Returns ErrPasswordMismatch when the hash is valid but the password is not.
+func CheckPassword(stored string, password string) error {
+
+	scheme, hash, err := SplitSchemeAndHash(stored)
+	if err != nil {
+		return err
+	}
+
+	switch scheme {
+	case "MD5-CRYPT":
+		return CheckHashedPasswordMD5Crypt(hash, password)
+	case "SHA256-CRYPT":
+		return CheckHashedPasswordSHA256Crypt(hash, password)
+	case "SHA512-CRYPT":
+		return CheckHashedPasswordSHA512Crypt(hash, password)
+	case "ARGON2I":
+		return CheckHashedPasswordArgon2I(hash, password)
+	case "ARGON2ID":
+		return CheckHashedPasswordArgon2ID(hash, password)
+	case "CRAM-MD5":
+		return CheckHashedPasswordCramMD5(hash, password)
+	case "SCRAM-SHA-256":
+		return CheckHashedPasswordScramSHA256(hash, password)
+	case "BLF-CRYPT":
+		err = CheckHashedPasswordBCrypt(hash, password)
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return ErrPasswordMismatch
+		}
+		return err
+	default:
+		return fmt.Errorf("unsupported password scheme %s", scheme)
+	}
+}
+
+// rough order of strength for automatic rehashing. Challenge-response schemes are missing
+// on purpose, they are chosen for the SASL mechanisms they allow and never rehashed.
+var schemeStrength = map[string]int{
+	"MD5-CRYPT":    1,
+	"SHA256-CRYPT": 2,
+	"SHA512-CRYPT": 3,
+	"BLF-CRYPT":    4,
+	"ARGON2I":      5,
+	"ARGON2ID":     6,
+}
+
+// IsWeakerScheme tells if passwords hashed with scheme should be rehashed with target.
+func IsWeakerScheme(scheme string, target string) bool {
+
+	s, ok := schemeStrength[scheme]
+	t, okTarget := schemeStrength[target]
+
+	return ok && okTarget && s < t
+}
+
+// ValidatePasswordHash checks that a {SCHEME}hash is of a supported scheme and well
+// formed, using the same parsers as CheckPassword.
+func ValidatePasswordHash(stored string) error {
+
+	scheme, hash, err := SplitSchemeAndHash(stored)
+	if err != nil {
+		return err
+	}
+
+	switch scheme {
+	case "MD5-CRYPT":
+		_, err = parseMD5Crypt(hash)
+	case "SHA256-CRYPT":
+		_, _, err = parseShaCrypt("$5$", sha256CryptHashLen, hash)
+	case "SHA512-CRYPT":
+		_, _, err = parseShaCrypt("$6$", sha512CryptHashLen, hash)
+	case "ARGON2I":
+		_, err = parseArgon2("argon2i", hash)
+	case "ARGON2ID":
+		_, err = parseArgon2("argon2id", hash)
+	case "CRAM-MD5":
+		_, err = parseCramMD5(hash)
+	case "SCRAM-SHA-256":
+		_, _, _, _, err = parseScramSHA256(hash)
+	case "BLF-CRYPT":
+		_, err = bcrypt.Cost([]byte(hash))
+	default:
+		err = fmt.Errorf("unsupported password scheme %s", scheme)
+	}
+
+	return err
+}
+
+// splits $1$salt$hash and returns the salt
+func parseMD5Crypt(hashed string) (string, error) {
+
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "1" {
+		return "", errors.New("not an MD5-CRYPT hash, expected $1$salt$hash")
+	}
+
+	salt := parts[2]
+	if len(salt) > 8 || strings.ContainsAny(salt, ":\n") {
+		return "", errors.New("invalid MD5-CRYPT salt")
+	}
+
+	if len(parts[3]) != 22 || !isP64(parts[3]) {
+		return "", errors.New("invalid MD5-CRYPT hash")
+	}
+
+	return salt, nil
+}
+
+func isP64(s string) bool {
+
+	for _, c := range s {
+		if !strings.ContainsRune(p64alphabet, c) {
+			return false
+		}
+	}
+
+	return true
 }
 
 func md5Pass64(b []byte) []byte {
@@ -192,3 +550,437 @@ func md5Crypt(plain []byte, salt []byte) ([]byte, error) {
 
 	return passwd, nil
 }
+
+func getShaCryptRounds() int {
+
+	rounds := GetIntFromConfig("hash.sha_crypt_rounds")
+	if rounds == 0 {
+
+		return shaCryptRoundsDefault
+	} else {
+
+		if rounds > shaCryptRoundsStoredMax {
+			LogWarn("hash.sha_crypt_rounds is above the rounds accepted when checking passwords, new hashes will be refused.",
+				log.Fields{"rounds": rounds, "max": shaCryptRoundsStoredMax})
+		}
+
+		return rounds
+	}
+}
+
+// splits $5$[rounds=N$]salt$hash and $6$..., returns salt and rounds (0 if not given)
+func parseShaCrypt(magic string, hashLen int, hashed string) (string, int, error) {
+
+	if !strings.HasPrefix(hashed, magic) {
+		return "", 0, fmt.Errorf("not a SHA-Crypt hash, expected %ssalt$hash", magic)
+	}
+
+	parts := strings.Split(hashed[len(magic):], "$")
+
+	rounds := 0
+	if len(parts) == 3 && strings.HasPrefix(parts[0], "rounds=") {
+
+		r, err := strconv.Atoi(strings.TrimPrefix(parts[0], "rounds="))
+		if err != nil || r < shaCryptRoundsMin || r > shaCryptRoundsStoredMax {
+			return "", 0, errors.New("invalid SHA-Crypt rounds")
+		}
+
+		rounds = r
+		parts = parts[1:]
+	}
+
+	if len(parts) != 2 {
+		return "", 0, errors.New("invalid SHA-Crypt hash")
+	}
+
+	salt := parts[0]
+	if len(salt) > shaCryptSaltMax || strings.ContainsAny(salt, ":\n") {
+		return "", 0, errors.New("invalid SHA-Crypt salt")
+	}
+
+	if len(parts[1]) != hashLen || !isP64(parts[1]) {
+		return "", 0, errors.New("invalid SHA-Crypt hash")
+	}
+
+	return salt, rounds, nil
+}
+
+func checkShaCrypt(newHash func() hash.Hash, magic string, hashLen int, hashed string, password string) error {
+
+	salt, rounds, err := parseShaCrypt(magic, hashLen, hashed)
+	if err != nil {
+		return err
+	}
+
+	// an explicit rounds=5000 has to be kept, otherwise the strings would differ
+	explicit := strings.HasPrefix(hashed[len(magic):], "rounds=")
+
+	hash, err := shaCrypt(newHash, magic, []byte(password), salt, rounds, explicit)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashed)) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+// SHA-Crypt as implemented by glibc, magic is $5$ for SHA-256 and $6$ for SHA-512
+func shaCrypt(newHash func() hash.Hash, magic string, key []byte, salt string, rounds int, explicitRounds bool) (string, error) {
+
+	if rounds == 0 {
+		rounds = getShaCryptRounds()
+	}
+
+	if rounds < shaCryptRoundsMin {
+		rounds = shaCryptRoundsMin
+	} else if rounds > shaCryptRoundsMax {
+		rounds = shaCryptRoundsMax
+	}
+
+	if len(salt) > shaCryptSaltMax {
+		salt = salt[:shaCryptSaltMax]
+	}
+	s := []byte(salt)
+
+	// digest B
+	h := newHash()
+	h.Write(key)
+	h.Write(s)
+	h.Write(key)
+	b := h.Sum(nil)
+	size := len(b)
+
+	// digest A
+	h.Reset()
+	h.Write(key)
+	h.Write(s)
+	for i := len(key); i > 0; i -= size {
+		if i > size {
+			h.Write(b)
+		} else {
+			h.Write(b[:i])
+		}
+	}
+	for i := len(key); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			h.Write(b)
+		} else {
+			h.Write(key)
+		}
+	}
+	a := h.Sum(nil)
+
+	// sequence P
+	h.Reset()
+	for i := 0; i < len(key); i++ {
+		h.Write(key)
+	}
+	dp := h.Sum(nil)
+	p := make([]byte, 0, len(key))
+	for i := len(key); i > 0; i -= size {
+		if i > size {
+			p = append(p, dp...)
+		} else {
+			p = append(p, dp[:i]...)
+		}
+	}
+
+	// sequence S
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	ds := h.Sum(nil)
+	sq := ds[:len(s)]
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+
+		if i&1 == 1 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+
+		if i%3 != 0 {
+			h.Write(sq)
+		}
+
+		if i%7 != 0 {
+			h.Write(p)
+		}
+
+		if i&1 == 1 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+
+		c = h.Sum(nil)
+	}
+
+	var out []byte
+	out = append(out, magic...)
+	if explicitRounds || rounds != shaCryptRoundsDefault {
+		out = append(out, "rounds="+strconv.Itoa(rounds)+"$"...)
+	}
+	out = append(out, s...)
+	out = append(out, '$')
+
+	if size == sha512.Size {
+		out = append(out, shaCryptPass64(c, sha512permute)...)
+		out = append(out, pass64Bits(uint(c[63]), 2)...)
+	} else {
+		out = append(out, shaCryptPass64(c, sha256permute)...)
+		out = append(out, pass64Bits(uint(c[31])<<8|uint(c[30]), 3)...)
+	}
+
+	return string(out), nil
+}
+
+func shaCryptPass64(b []byte, permute [][3]int) []byte {
+
+	var pass []byte
+
+	for _, v := range permute {
+		pass = append(pass, pass64Bits(uint(b[v[0]])<<16|uint(b[v[1]])<<8|uint(b[v[2]]), 4)...)
+	}
+
+	return pass
+}
+
+func pass64Bits(v uint, n int) []byte {
+
+	pass := make([]byte, 0, n)
+
+	for i := 0; i < n; i++ {
+		pass = append(pass, p64alphabet[v&0x3f])
+		v >>= 6
+	}
+
+	return pass
+}
+
+type argon2Params struct {
+	memory uint32
+	time   uint32
+	salt   []byte
+	key    []byte
+}
+
+func getArgon2Memory() uint32 {
+
+	memory := GetIntFromConfig("hash.argon2_memory")
+	if memory <= 0 {
+
+		return argon2MemoryDefault
+	} else if memory > argon2MemoryMax {
+
+		return argon2MemoryMax
+	} else {
+
+		return uint32(memory)
+	}
+}
+
+func getArgon2Time() uint32 {
+
+	time := GetIntFromConfig("hash.argon2_time")
+	if time <= 0 {
+
+		return argon2TimeDefault
+	} else if time > argon2TimeMax {
+
+		return argon2TimeMax
+	} else {
+
+		return uint32(time)
+	}
+}
+
+func argon2Key(variant string, pwd []byte, p argon2Params, keyLen uint32) []byte {
+
+	if variant == "argon2i" {
+		return argon2.Key(pwd, p.salt, p.time, p.memory, argon2Threads, keyLen)
+	}
+
+	return argon2.IDKey(pwd, p.salt, p.time, p.memory, argon2Threads, keyLen)
+}
+
+// encodes like libsodium's crypto_pwhash_str: $argon2id$v=19$m=65536,t=3,p=1$salt$hash
+func argon2Hash(variant string, pwd []byte, memory uint32, time uint32) (string, error) {
+
+	p := argon2Params{memory: memory, time: time, salt: make([]byte, argon2SaltBytes)}
+
+	if p.memory == 0 {
+		p.memory = getArgon2Memory()
+	}
+
+	if p.time == 0 {
+		p.time = getArgon2Time()
+	}
+
+	_, err := rand.Read(p.salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2Key(variant, pwd, p, argon2KeyBytes)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", variant, argon2.Version, p.memory, p.time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(p.salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func parseArgon2(variant string, hashed string) (argon2Params, error) {
+
+	var p argon2Params
+
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != variant {
+		return p, fmt.Errorf("not an %s hash, expected $%s$v=19$m=..,t=..,p=..$salt$hash", strings.ToUpper(variant), variant)
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, errors.New("unsupported Argon2 version")
+	}
+
+	var threads uint8
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &threads)
+	if err != nil || p.memory == 0 || p.time == 0 || threads != argon2Threads ||
+		p.memory > argon2MemoryMax || p.time > argon2TimeMax {
+		return p, errors.New("invalid Argon2 parameters")
+	}
+
+	p.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(p.salt) < 8 {
+		return p, errors.New("invalid Argon2 salt")
+	}
+
+	p.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(p.key) < 16 {
+		return p, errors.New("invalid Argon2 hash")
+	}
+
+	return p, nil
+}
+
+func checkArgon2(variant string, hashed string, password string) error {
+
+	p, err := parseArgon2(variant, hashed)
+	if err != nil {
+		return err
+	}
+
+	key := argon2Key(variant, []byte(password), p, uint32(len(p.key)))
+
+	if subtle.ConstantTimeCompare(key, p.key) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+// returns the MD5 states of the outer and inner HMAC pads, a, b, c, d each, little endian
+func cramMD5Context(key []byte) []byte {
+
+	if len(key) > md5.BlockSize {
+		sum := md5.Sum(key)
+		key = sum[:]
+	}
+
+	ipad := make([]byte, md5.BlockSize)
+	opad := make([]byte, md5.BlockSize)
+	copy(ipad, key)
+	copy(opad, key)
+
+	for i := range ipad {
+		ipad[i] ^= 0x36
+		opad[i] ^= 0x5c
+	}
+
+	context := make([]byte, 0, 32)
+	context = append(context, md5State(opad)...)
+	context = append(context, md5State(ipad)...)
+
+	return context
+}
+
+// state of MD5 after processing exactly one block
+func md5State(block []byte) []byte {
+
+	h := md5.New()
+	h.Write(block)
+
+	// crypto/md5 marshals as magic (4 bytes) followed by a, b, c, d, big endian
+	m, _ := h.(interface{ MarshalBinary() ([]byte, error) }).MarshalBinary()
+
+	state := make([]byte, 16)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint32(state[i*4:], binary.BigEndian.Uint32(m[4+i*4:]))
+	}
+
+	return state
+}
+
+func parseCramMD5(hashed string) ([]byte, error) {
+
+	stored, err := hex.DecodeString(hashed)
+	if err != nil || len(stored) != 32 {
+		return nil, errors.New("invalid CRAM-MD5 credentials, expected 64 hex digits")
+	}
+
+	return stored, nil
+}
+
+func scramKeys(pwd []byte, salt []byte, iterations int) ([]byte, []byte) {
+
+	salted := pbkdf2.Key(pwd, salt, iterations, sha256.Size, sha256.New)
+
+	mac := hmac.New(sha256.New, salted)
+	mac.Write([]byte("Client Key"))
+	clientKey := mac.Sum(nil)
+
+	storedKey := sha256.Sum256(clientKey)
+
+	mac = hmac.New(sha256.New, salted)
+	mac.Write([]byte("Server Key"))
+	serverKey := mac.Sum(nil)
+
+	return storedKey[:], serverKey
+}
+
+func parseScramSHA256(hashed string) (int, []byte, []byte, []byte, error) {
+
+	parts := strings.Split(hashed, ",")
+	if len(parts) != 4 {
+		return 0, nil, nil, nil, errors.New("invalid SCRAM-SHA-256 credentials, expected iterations,salt,storedkey,serverkey")
+	}
+
+	iterations, err := strconv.Atoi(parts[0])
+	if err != nil || iterations < 1 || iterations > scramIterationsMax {
+		return 0, nil, nil, nil, errors.New("invalid SCRAM-SHA-256 iteration count")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(salt) == 0 {
+		return 0, nil, nil, nil, errors.New("invalid SCRAM-SHA-256 salt")
+	}
+
+	storedKey, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil || len(storedKey) != sha256.Size {
+		return 0, nil, nil, nil, errors.New("invalid SCRAM-SHA-256 stored key")
+	}
+
+	serverKey, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(serverKey) != sha256.Size {
+		return 0, nil, nil, nil, errors.New("invalid SCRAM-SHA-256 server key")
+	}
+
+	return iterations, salt, storedKey, serverKey, nil
+}
@@ -0,0 +1,207 @@
+package common
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"strings"
+	"testing"
+)
+
+// vectors from libc crypt(3), OpenSSL's MD5 and Python's hashlib, the Argon2 ones from
+// the reference implementation
+func TestCheckPassword(t *testing.T) {
+
+	tests := []struct {
+		stored   string
+		password string
+		want     error
+	}{
+		{"{MD5-CRYPT}$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "password", nil},
+		{"{MD5-CRYPT}$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "Password", ErrPasswordMismatch},
+		{"{SHA256-CRYPT}$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!", nil},
+		{"{SHA256-CRYPT}$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world", ErrPasswordMismatch},
+		{"{SHA512-CRYPT}$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "Hello world!", nil},
+		{"{SHA512-CRYPT}$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "", ErrPasswordMismatch},
+		{"{BLF-CRYPT}$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U", nil},
+		{"{BLF-CRYPT}$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*V", ErrPasswordMismatch},
+		{"{ARGON2I}$argon2i$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$wWKIMhR9lyDFvRz9YTZweHKfbftvj+qf+YFY4NeBbtA", "password", nil},
+		{"{ARGON2I}$argon2i$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$wWKIMhR9lyDFvRz9YTZweHKfbftvj+qf+YFY4NeBbtA", "passwort", ErrPasswordMismatch},
+		{"{ARGON2ID}$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password", nil},
+		{"{ARGON2ID}$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "passwort", ErrPasswordMismatch},
+		{"{CRAM-MD5}9186d855e11eba527a7a52ca82b313e180d62234f0acc9051b527243d41e2740", "password", nil},
+		{"{CRAM-MD5}9186d855e11eba527a7a52ca82b313e180d62234f0acc9051b527243d41e2740", "pencil", ErrPasswordMismatch},
+		{"{SCRAM-SHA-256}4096,c2FsdHNhbHRzYWx0c2FsdA==,CozjiHjNmiMjBgH9gZ7qn0QWud6nrVP6E72IBh477bQ=,VKers2x8MllK1Rh7LZLqtj6KOTzoFWJpIaokMX3blS0=", "password", nil},
+		{"{SCRAM-SHA-256}4096,c2FsdHNhbHRzYWx0c2FsdA==,CozjiHjNmiMjBgH9gZ7qn0QWud6nrVP6E72IBh477bQ=,VKers2x8MllK1Rh7LZLqtj6KOTzoFWJpIaokMX3blS0=", "pencil", ErrPasswordMismatch},
+		// the scheme prefix is case insensitive
+		{"{md5-crypt}$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "password", nil},
+	}
+
+	for _, tt := range tests {
+
+		err := CheckPassword(tt.stored, tt.password)
+		if err != tt.want {
+			t.Errorf("CheckPassword(%q, %q) = %v, want %v", tt.stored, tt.password, err, tt.want)
+		}
+	}
+}
+
+func TestHashPasswordVectors(t *testing.T) {
+
+	tests := []struct {
+		name string
+		hash func() (string, error)
+		want string
+	}{
+		{"MD5-CRYPT", func() (string, error) { return HashPasswordMD5Crypt("password", "saltsalt") },
+			"$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/"},
+		{"SHA256-CRYPT", func() (string, error) { return HashPasswordSHA256Crypt("Hello world!", "saltstring", 0) },
+			"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		// the salt is cut to 16 characters
+		{"SHA512-CRYPT", func() (string, error) {
+			return HashPasswordSHA512Crypt("Hello world!", "saltstringsaltstring", 10000)
+		}, "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+		{"CRAM-MD5", func() (string, error) { return HashPasswordCramMD5("password") },
+			"9186d855e11eba527a7a52ca82b313e180d62234f0acc9051b527243d41e2740"},
+		{"CRAM-MD5 empty", func() (string, error) { return HashPasswordCramMD5("") },
+			"00747cf2ffaf11c5ea4a64979c3901fc1d20dee13f480bb598f7d8575b23e61b"},
+	}
+
+	for _, tt := range tests {
+
+		got, err := tt.hash()
+		if err != nil || got != tt.want {
+			t.Errorf("%s = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// salted schemes can only be checked against themselves
+func TestHashPasswordRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		scheme string
+		hash   func(pwd string) (string, error)
+	}{
+		{"BLF-CRYPT", HashPasswordBCrypt},
+		{"ARGON2I", func(pwd string) (string, error) { return HashPasswordArgon2I(pwd, 1024, 1) }},
+		{"ARGON2ID", func(pwd string) (string, error) { return HashPasswordArgon2ID(pwd, 1024, 1) }},
+		{"SCRAM-SHA-256", HashPasswordScramSHA256},
+	}
+
+	for _, tt := range tests {
+
+		hash, err := tt.hash("secret")
+		if err != nil {
+			t.Errorf("%s: %v", tt.scheme, err)
+			continue
+		}
+
+		stored := "{" + tt.scheme + "}" + hash
+
+		if err = ValidatePasswordHash(stored); err != nil {
+			t.Errorf("ValidatePasswordHash(%q) = %v", stored, err)
+		}
+		if err = CheckPassword(stored, "secret"); err != nil {
+			t.Errorf("CheckPassword(%q, secret) = %v", stored, err)
+		}
+		if err = CheckPassword(stored, "Secret"); err != ErrPasswordMismatch {
+			t.Errorf("CheckPassword(%q, Secret) = %v, want mismatch", stored, err)
+		}
+	}
+}
+
+func TestValidatePasswordHashMalformed(t *testing.T) {
+
+	tests := []struct {
+		stored string
+		reason string
+	}{
+		{"$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "no scheme"},
+		{"{}$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "empty scheme"},
+		{"{PLAIN}secret", "unsupported scheme"},
+		{"{MD5-CRYPT}$5$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "wrong magic"},
+		{"{MD5-CRYPT}$1$saltsalt$qjXMvbEw8oaL", "short hash"},
+		{"{SHA256-CRYPT}$5$rounds=x$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "invalid rounds"},
+		{"{SHA512-CRYPT}$6$rounds=999999999$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "too many rounds"},
+		{"{SHA512-CRYPT}$6$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "short hash"},
+		{"{BLF-CRYPT}$2b$05$CCCC", "short hash"},
+		{"{ARGON2I}$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "wrong variant"},
+		{"{ARGON2ID}$argon2id$v=16$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "old version"},
+		{"{ARGON2ID}$argon2id$v=19$m=65536,t=2,p=4$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "threads"},
+		{"{ARGON2ID}$argon2id$v=19$m=4294967295,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "too much memory"},
+		{"{ARGON2ID}$argon2id$v=19$m=65536,t=100000,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "too many passes"},
+		{"{ARGON2ID}$argon2id$v=19$m=65536,t=2,p=1$c29t$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "short salt"},
+		{"{CRAM-MD5}9186d855e11eba527a7a52ca82b313e1", "short context"},
+		{"{CRAM-MD5}" + strings.Repeat("x", 64), "no hex"},
+		{"{SCRAM-SHA-256}4096,c2FsdHNhbHRzYWx0c2FsdA==,CozjiHjNmiMjBgH9gZ7qn0QWud6nrVP6E72IBh477bQ=", "missing key"},
+		{"{SCRAM-SHA-256}0,c2FsdHNhbHRzYWx0c2FsdA==,CozjiHjNmiMjBgH9gZ7qn0QWud6nrVP6E72IBh477bQ=,VKers2x8MllK1Rh7LZLqtj6KOTzoFWJpIaokMX3blS0=", "no iterations"},
+		{"{SCRAM-SHA-256}999999999,c2FsdHNhbHRzYWx0c2FsdA==,CozjiHjNmiMjBgH9gZ7qn0QWud6nrVP6E72IBh477bQ=,VKers2x8MllK1Rh7LZLqtj6KOTzoFWJpIaokMX3blS0=", "too many iterations"},
+		{"{SCRAM-SHA-256}4096,c2FsdHNhbHRzYWx0c2FsdA==,CozjiHjNmiMjBgH9gZ7qn0QWud6nrVP6E72IBh477bQ=,VKers2x8", "short server key"},
+	}
+
+	for _, tt := range tests {
+
+		if err := ValidatePasswordHash(tt.stored); err == nil {
+			t.Errorf("ValidatePasswordHash(%q) accepted, %s", tt.stored, tt.reason)
+		}
+
+		// a malformed hash is an error, never a match or a mismatch
+		if err := CheckPassword(tt.stored, "secret"); err == nil || err == ErrPasswordMismatch {
+			t.Errorf("CheckPassword(%q) = %v, %s", tt.stored, err, tt.reason)
+		}
+	}
+}
+
+func TestDetectScheme(t *testing.T) {
+
+	tests := []struct {
+		hash string
+		want string
+	}{
+		{"$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "MD5-CRYPT"},
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "SHA256-CRYPT"},
+		{"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "SHA512-CRYPT"},
+		{"$2y$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "BLF-CRYPT"},
+		{"$argon2i$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$wWKIMhR9lyDFvRz9YTZweHKfbftvj+qf+YFY4NeBbtA", "ARGON2I"},
+		{"$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "ARGON2ID"},
+		{"$y$j9T$abc", ""},
+		{"secret", ""},
+	}
+
+	for _, tt := range tests {
+
+		if got := DetectScheme(tt.hash); got != tt.want {
+			t.Errorf("DetectScheme(%q) = %q, want %q", tt.hash, got, tt.want)
+		}
+	}
+}
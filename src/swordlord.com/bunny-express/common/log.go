@@ -34,6 +34,7 @@ package common
 
 import (
 	log "github.com/sirupsen/logrus"
+	"io"
 	"os"
 )
 
@@ -52,6 +53,11 @@ func InitLog() {
 	}
 }
 
+func SetLogOutput(out io.Writer) {
+
+	log.SetOutput(out)
+}
+
 func LogTrace(msg string, fields log.Fields) {
 
 	if fields == nil {
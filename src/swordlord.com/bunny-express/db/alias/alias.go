@@ -41,19 +41,21 @@ import (
 	"strings"
 	"swordlord.com/bunny-express/common"
 	"swordlord.com/bunny-express/db"
+	"swordlord.com/bunny-express/db/domain"
 	"time"
 )
 
 type Alias struct {
-	Alias                 string         `db:"alias"`
-	Description           sql.NullString `db:"desc"`
-	isDescDirty           bool
-	Domain                string `db:"domain"`
-	isDomainDirty         bool
-	ForwardAddress        string `db:"forward_address"`
-	isForwardAddressDirty bool
-	IsActive              bool `db:"active"`
-	isIsActiveDirty       bool
+	Alias         string         `db:"alias"`
+	Description   sql.NullString `db:"desc"`
+	isDescDirty   bool
+	Domain        string `db:"domain"`
+	isDomainDirty bool
+	// forward addresses, stored in alias_target
+	Targets         []string `db:"-"`
+	isTargetsDirty  bool
+	IsActive        bool `db:"active"`
+	isIsActiveDirty bool
 	// tells us if object is from db or not
 	isNew  bool
 	CrtDat time.Time `db:"crt_dat"`
@@ -65,6 +67,7 @@ func NewAlias() *Alias {
 	a := &Alias{}
 	a.clearDirtyFlags()
 	a.isNew = true
+	a.SetIsActive(true)
 	a.CrtDat = time.Now()
 	a.UpdDat = time.Now()
 
@@ -74,14 +77,14 @@ func NewAlias() *Alias {
 func (a *Alias) clearDirtyFlags() {
 	a.isDescDirty = false
 	a.isDomainDirty = false
-	a.isForwardAddressDirty = false
+	a.isTargetsDirty = false
 	a.isIsActiveDirty = false
 }
 
 func (a *Alias) GetAlias() string               { return a.Alias }
 func (a *Alias) GetDescription() sql.NullString { return a.Description }
 func (a *Alias) GetDomain() string              { return a.Domain }
-func (a *Alias) GetForwardAddress() string      { return a.ForwardAddress }
+func (a *Alias) GetTargets() []string           { return a.Targets }
 func (a *Alias) GetIsActive() bool              { return a.IsActive }
 
 func (a *Alias) SetAlias(aliass string) {
@@ -104,14 +107,93 @@ func (a *Alias) SetDomain(domain string) {
 	a.isDomainDirty = true
 }
 
-func (a *Alias) SetForwardAddress(fa string) {
+// SetTargets replaces the forward addresses, each one has to be valid.
+func (a *Alias) SetTargets(targets []string) error {
 
-	if a.ForwardAddress == fa {
-		return
+	var unique []string
+
+	for _, t := range targets {
+
+		t = strings.TrimSpace(t)
+
+		err := ValidateTarget(t)
+		if err != nil {
+			return err
+		}
+
+		if !containsTarget(unique, t) {
+			unique = append(unique, t)
+		}
+	}
+
+	if strings.Join(a.Targets, ",") == strings.Join(unique, ",") {
+		return nil
 	}
 
-	a.ForwardAddress = fa
-	a.isForwardAddressDirty = true
+	a.Targets = unique
+	a.isTargetsDirty = true
+
+	return nil
+}
+
+func (a *Alias) AddTarget(target string) error {
+
+	if containsTarget(a.Targets, target) {
+		return errors.New(target + " is a target of " + a.Alias + " already")
+	}
+
+	return a.SetTargets(append(append([]string{}, a.Targets...), target))
+}
+
+func (a *Alias) RemoveTarget(target string) error {
+
+	if !containsTarget(a.Targets, target) {
+		return errors.New(target + " is no target of " + a.Alias)
+	}
+
+	var targets []string
+	for _, t := range a.Targets {
+		if t != target {
+			targets = append(targets, t)
+		}
+	}
+
+	a.Targets = targets
+	a.isTargetsDirty = true
+
+	return nil
+}
+
+func containsTarget(targets []string, target string) bool {
+
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateTarget checks that the target is a single address of the form local@domain.
+func ValidateTarget(target string) error {
+
+	at := strings.LastIndex(target, "@")
+
+	if at < 1 || at == len(target)-1 || len(target) > 255 || strings.ContainsAny(target, " ,;:<>\t\r\n") {
+		return errors.New("invalid target address '" + target + "'")
+	}
+
+	return nil
+}
+
+// ParseTargets splits a list of addresses separated by blanks or commas, as used by
+// Postfix and in older versions of bunnyexpress.
+func ParseTargets(list string) []string {
+
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t'
+	})
 }
 
 func (a *Alias) SetIsActive(ia bool) {
@@ -125,7 +207,7 @@ func (a *Alias) SetIsActive(ia bool) {
 }
 
 func (a *Alias) IsDirty() bool {
-	if a.isDescDirty || a.isDomainDirty || a.isForwardAddressDirty || a.isIsActiveDirty {
+	if a.isDescDirty || a.isDomainDirty || a.isTargetsDirty || a.isIsActiveDirty {
 		return true
 	} else {
 		return false
@@ -133,11 +215,11 @@ func (a *Alias) IsDirty() bool {
 }
 
 type AliasFilter struct {
-	Alias          string
-	Description    string
-	Domain         string
-	ForwardAddress string
-	IsActive       sql.NullBool
+	Alias       string
+	Description string
+	Domain      string
+	Target      string
+	IsActive    sql.NullBool
 }
 
 func GetFieldCaptions() []string {
@@ -163,17 +245,25 @@ func GetFilteredAliases(af *AliasFilter) ([]Alias, error) {
 	sFilter := ""
 	params := []string{}
 
+	if len(af.Alias) > 0 {
+		sFilter += "alias LIKE ?"
+		params = append(params, af.Alias)
+	}
+
 	if len(af.Domain) > 0 {
+		if len(sFilter) > 0 {
+			sFilter += " AND "
+		}
 		sFilter += "domain LIKE ?"
 		params = append(params, af.Domain)
 	}
 
-	if len(af.ForwardAddress) > 0 {
+	if len(af.Target) > 0 {
 		if len(sFilter) > 0 {
 			sFilter += " AND "
 		}
-		sFilter += "forward_address LIKE ?"
-		params = append(params, af.ForwardAddress)
+		sFilter += "alias IN (SELECT alias FROM alias_target WHERE target LIKE ?)"
+		params = append(params, af.Target)
 	}
 
 	if af.IsActive.Valid {
@@ -205,14 +295,103 @@ func GetFilteredAliases(af *AliasFilter) ([]Alias, error) {
 	// select function accepts a slice of interface as variadic, neat
 	var a []Alias
 	err = stmt.Select(&a, args...)
+	if err != nil {
+		return a, err
+	}
+
+	targets, err := getTargets(db, "")
+	if err != nil {
+		return a, err
+	}
+
+	for i := range a {
+		a[i].isNew = false
+		a[i].Targets = targets[a[i].Alias]
+	}
+
+	return a, nil
+}
+
+// targets of the given alias, of all aliases if empty
+func getTargets(db *sqlx.DB, alias string) (map[string][]string, error) {
+
+	sql := "SELECT alias, target FROM alias_target"
+	var params []interface{}
+
+	if alias != "" {
+		sql += " WHERE alias = ?"
+		params = append(params, alias)
+	}
+
+	rows, err := db.Queryx(sql+" ORDER BY alias, target", params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := make(map[string][]string)
+
+	for rows.Next() {
+
+		var a, t string
+
+		err = rows.Scan(&a, &t)
+		if err != nil {
+			return nil, err
+		}
+
+		targets[a] = append(targets[a], t)
+	}
+
+	return targets, rows.Err()
+}
+
+// replaces the targets stored for the alias, called by add and update within their
+// transaction
+func (a *Alias) saveTargets(tx *sqlx.Tx) error {
+
+	if !a.isTargetsDirty {
+		return nil
+	}
+
+	_, err := tx.Exec("DELETE FROM alias_target WHERE alias = ?", a.Alias)
+	if err != nil {
+		return err
+	}
 
-	if err == nil {
-		for i := range a {
-			a[i].isNew = false
+	for _, t := range a.Targets {
+
+		_, err = tx.Exec("INSERT INTO alias_target (alias, target, crt_dat) VALUES (?, ?, ?)", a.Alias, t, time.Now())
+		if err != nil {
+			return err
 		}
 	}
 
-	return a, err
+	return nil
+}
+
+// IsCatchAll tells if the address is the catch-all of a domain, @domain.
+func IsCatchAll(address string) bool {
+
+	return strings.HasPrefix(address, "@")
+}
+
+// GetCatchAllAddress returns the address of the catch-all of the domain.
+func GetCatchAllAddress(domain string) string {
+
+	return "@" + domain
+}
+
+// GetCatchAll returns the catch-all of the domain, sql.ErrNoRows if it has none.
+func GetCatchAll(domain string) (*Alias, error) {
+
+	return GetAlias(GetCatchAllAddress(domain))
+}
+
+// GetCatchAlls returns the catch-alls of all domains.
+func GetCatchAlls() ([]Alias, error) {
+
+	return GetFilteredAliases(&AliasFilter{Alias: GetCatchAllAddress("%")})
 }
 
 func GetAlias(name string) (*Alias, error) {
@@ -232,10 +411,19 @@ func GetAlias(name string) (*Alias, error) {
 	err = stmt.Get(a, name)
 	if err != nil {
 		return NewAlias(), err
-	} else {
-		a.isNew = false
-		return a, nil
 	}
+
+	targets, err := getTargets(db, name)
+	if err != nil {
+		return NewAlias(), err
+	}
+
+	// the defaults of NewAlias are no changes
+	a.clearDirtyFlags()
+	a.isNew = false
+	a.Targets = targets[name]
+
+	return a, nil
 }
 
 func (a *Alias) Persist() error {
@@ -251,17 +439,69 @@ func (a *Alias) Persist() error {
 		return nil
 	}
 
+	err = a.validate()
+	if err != nil {
+		return err
+	}
+
+	if a.isNew || a.isDomainDirty {
+		err = domain.CheckAliasLimits(db, a.Domain, a.Alias)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the alias and its targets are written together
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
 	if a.isNew {
-		err = a.add(db)
+		err = a.add(tx)
 	} else {
-		err = a.update(db)
+		err = a.update(tx)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// an alias needs a target, a catch-all has to be the one of its domain, and there is
+// only one per domain
+func (a *Alias) validate() error {
+
+	if len(a.Targets) == 0 {
+		return errors.New("alias " + a.Alias + " has no target")
+	}
+
+	if !IsCatchAll(a.Alias) {
+		return nil
+	}
+
+	if a.Alias != GetCatchAllAddress(a.Domain) {
+		return errors.New("catch-all " + a.Alias + " does not belong to domain " + a.Domain)
+	}
+
+	if a.isNew {
+
+		existing, err := GetCatchAll(a.Domain)
+		if err == nil {
+			return errors.New("domain " + a.Domain + " has a catch-all already: " + existing.Alias)
+		} else if err != sql.ErrNoRows {
+			return err
+		}
 	}
 
-	return err
+	return nil
 }
 
 // called by a.Persist, never call directly
-func (a *Alias) add(db *sqlx.DB) error {
+func (a *Alias) add(tx *sqlx.Tx) error {
 
 	sFields := ""
 	var params []interface{}
@@ -271,7 +511,7 @@ func (a *Alias) add(db *sqlx.DB) error {
 		params = append(params, a.Alias)
 	}
 
-	// a.ForwardAddress, a.Description, a.IsActive, time.Now(), a.Alias, a.UpdDat
+	// a.Description, a.IsActive, time.Now(), a.Alias, a.UpdDat
 	if a.isDomainDirty {
 		if len(sFields) > 0 {
 			sFields += ", "
@@ -288,14 +528,6 @@ func (a *Alias) add(db *sqlx.DB) error {
 		params = append(params, a.Description.String)
 	}
 
-	if a.isForwardAddressDirty {
-		if len(sFields) > 0 {
-			sFields += ", "
-		}
-		sFields += "forward_address"
-		params = append(params, a.ForwardAddress)
-	}
-
 	if a.isIsActiveDirty {
 		if len(sFields) > 0 {
 			sFields += ", "
@@ -315,7 +547,7 @@ func (a *Alias) add(db *sqlx.DB) error {
 	sQM := strings.Repeat("?,", len(params))
 	sQM = sQM[:len(sQM)-1]
 
-	stmt, err := db.Preparex("INSERT INTO alias (" + sFields + ") VALUES (" + sQM + ")")
+	stmt, err := tx.Preparex("INSERT INTO alias (" + sFields + ") VALUES (" + sQM + ")")
 	if err != nil {
 		return err
 	}
@@ -330,7 +562,14 @@ func (a *Alias) add(db *sqlx.DB) error {
 		return err
 	}
 
-	fields := logrus.Fields{"alias": a.Alias, "domain": a.Domain, "forward": a.ForwardAddress, "description": a.Description, "active": a.IsActive}
+	if count > 0 {
+		err = a.saveTargets(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	fields := logrus.Fields{"alias": a.Alias, "domain": a.Domain, "targets": strings.Join(a.Targets, ","), "description": a.Description, "active": a.IsActive}
 
 	a.clearDirtyFlags()
 
@@ -344,7 +583,7 @@ func (a *Alias) add(db *sqlx.DB) error {
 }
 
 // called by a.Persist, never call directly
-func (a *Alias) update(db *sqlx.DB) error {
+func (a *Alias) update(tx *sqlx.Tx) error {
 
 	if !a.IsDirty() {
 		return errors.New("trying to update unchanged object")
@@ -353,7 +592,7 @@ func (a *Alias) update(db *sqlx.DB) error {
 	sStatement := ""
 	var params []interface{}
 
-	// a.ForwardAddress, a.Description, a.IsActive, time.Now(), a.Alias, a.UpdDat
+	// a.Description, a.IsActive, time.Now(), a.Alias, a.UpdDat
 	if a.isDomainDirty {
 		sStatement += "domain = ?"
 		params = append(params, a.Domain)
@@ -367,14 +606,6 @@ func (a *Alias) update(db *sqlx.DB) error {
 		params = append(params, a.Description.String)
 	}
 
-	if a.isForwardAddressDirty {
-		if len(sStatement) > 0 {
-			sStatement += ", "
-		}
-		sStatement += "forward_address = ?"
-		params = append(params, a.ForwardAddress)
-	}
-
 	if a.isIsActiveDirty {
 		if len(sStatement) > 0 {
 			sStatement += ", "
@@ -394,7 +625,7 @@ func (a *Alias) update(db *sqlx.DB) error {
 	params = append(params, a.Alias)  // pkey
 	params = append(params, a.UpdDat) // optimistic locking
 
-	stmt, err := db.Preparex("UPDATE alias SET " + sStatement + " WHERE alias = ? AND upd_dat <= ?")
+	stmt, err := tx.Preparex("UPDATE alias SET " + sStatement + " WHERE alias = ? AND upd_dat <= ?")
 	if err != nil {
 		return err
 	}
@@ -409,7 +640,14 @@ func (a *Alias) update(db *sqlx.DB) error {
 		return err
 	}
 
-	fields := logrus.Fields{"alias": a.Alias, "domain": a.Domain, "forward": a.ForwardAddress, "description": a.Description, "active": a.IsActive}
+	if count > 0 {
+		err = a.saveTargets(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	fields := logrus.Fields{"alias": a.Alias, "domain": a.Domain, "targets": strings.Join(a.Targets, ","), "description": a.Description, "active": a.IsActive}
 
 	a.clearDirtyFlags()
 
@@ -435,17 +673,31 @@ func DeleteAlias(alias string) error {
 	}
 	defer db.Close()
 
-	stmt, err := db.Preparex(`DELETE FROM alias WHERE alias=?`)
+	// the targets go with the alias or not at all
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM alias_target WHERE alias=?", alias)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	res, err := stmt.Exec(alias)
+	res, err := tx.Exec(`DELETE FROM alias WHERE alias=?`, alias)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
 	count, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
@@ -468,7 +720,7 @@ func FillDefaultAliasOnDomain(domain string) error {
 		alias := NewAlias()
 		alias.SetDomain(domain)
 		alias.SetAlias(an + "@" + domain)
-		alias.SetForwardAddress("root@" + domain)
+		alias.SetTargets([]string{"root@" + domain})
 		alias.SetIsActive(true)
 
 		var desc sql.NullString
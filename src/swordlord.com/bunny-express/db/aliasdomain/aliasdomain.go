@@ -0,0 +1,207 @@
+package aliasdomain
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/db"
+	"swordlord.com/bunny-express/db/domain"
+	"time"
+)
+
+// an alias domain has no mailboxes or aliases of its own, user@alias_domain is
+// delivered to user@target_domain
+type AliasDomain struct {
+	AliasDomain  string    `db:"alias_domain"`
+	TargetDomain string    `db:"target_domain"`
+	CrtDat       time.Time `db:"crt_dat"`
+}
+
+func GetFieldCaptions() []string {
+
+	captions := []string{"AliasDomain", "TargetDomain", "Created"}
+
+	return captions
+}
+
+// GetAliasDomains returns all alias domains, the ones pointing to target only when
+// target is not empty.
+func GetAliasDomains(target string) ([]AliasDomain, error) {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var ad []AliasDomain
+
+	if target == "" {
+		err = db.Select(&ad, "SELECT * FROM alias_domain ORDER BY alias_domain ASC")
+	} else {
+		err = db.Select(&ad, "SELECT * FROM alias_domain WHERE target_domain = ? ORDER BY alias_domain ASC", target)
+	}
+
+	return ad, err
+}
+
+// GetAliasDomain returns sql.ErrNoRows when name is no alias domain.
+func GetAliasDomain(name string) (*AliasDomain, error) {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ad := &AliasDomain{}
+
+	err = db.Get(ad, "SELECT * FROM alias_domain WHERE alias_domain = ?", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ad, nil
+}
+
+// GetTargetAddress returns the address user@alias_domain is delivered to, an empty
+// string when the domain of address is no alias domain.
+func GetTargetAddress(address string) (string, error) {
+
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return "", nil
+	}
+
+	ad, err := GetAliasDomain(address[i+1:])
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return address[:i+1] + ad.TargetDomain, nil
+}
+
+// AddAliasDomain lets target receive the mail of name. Target has to be an existing
+// virtual or alias domain, name must not be a domain, so alias domains can't be chained.
+func AddAliasDomain(name string, target string) error {
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	target = strings.ToLower(strings.TrimSpace(target))
+
+	if name == "" || strings.ContainsAny(name, "@ \t") {
+		return fmt.Errorf("invalid alias domain '%s'", name)
+	}
+
+	if name == target {
+		return fmt.Errorf("alias domain %s can't point to itself", name)
+	}
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// relay and backup MX domains have no addresses of their own to map to
+	var targetType string
+
+	err = db.Get(&targetType, "SELECT type FROM domain WHERE domain = ?", target)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("target domain %s does not exist", target)
+	} else if err != nil {
+		return err
+	}
+	if targetType != domain.TypeVirtual && targetType != domain.TypeAlias {
+		return fmt.Errorf("target domain %s is of type %s, alias domains can only point to virtual or alias domains", target, targetType)
+	}
+
+	var count int
+
+	err = db.Get(&count, "SELECT count(*) FROM domain WHERE domain = ?", name)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%s is a domain already, delete it first", name)
+	}
+
+	err = db.Get(&count, "SELECT count(*) FROM alias_domain WHERE alias_domain = ?", name)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("alias domain %s exists already", name)
+	}
+
+	_, err = db.Exec("INSERT INTO alias_domain (alias_domain, target_domain, crt_dat) VALUES (?, ?, ?)", name, target, time.Now())
+	if err != nil {
+		return err
+	}
+
+	common.LogInfo("Alias domain added.", logrus.Fields{"aliasdomain": name, "target": target})
+
+	return nil
+}
+
+func DeleteAliasDomain(name string) error {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("DELETE FROM alias_domain WHERE alias_domain = ?", name)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		common.LogInfo("Nothing deleted. Wrong alias domain used?", logrus.Fields{"aliasdomain": name})
+	} else {
+		common.LogInfo("Alias domain deleted.", logrus.Fields{"aliasdomain": name})
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package authfailure
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"database/sql"
+	"swordlord.com/bunny-express/db"
+	"time"
+)
+
+// failures are counted per login name and per remote IP
+const (
+	KindLogin = "login"
+	KindIP    = "ip"
+)
+
+type AuthFailure struct {
+	Kind     string    `db:"kind"`
+	Name     string    `db:"name"`
+	Failures int       `db:"failures"`
+	CrtDat   time.Time `db:"crt_dat"`
+	UpdDat   time.Time `db:"upd_dat"`
+}
+
+func GetFieldCaptions() []string {
+
+	captions := []string{"Kind", "Name", "Failures", "First", "Last", "State"}
+
+	return captions
+}
+
+// GetAuthFailures returns all entries with a failure within window, newest first.
+func GetAuthFailures(window time.Duration) ([]AuthFailure, error) {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var af []AuthFailure
+
+	err = db.Select(&af, "SELECT * FROM auth_failure WHERE upd_dat >= ? ORDER BY upd_dat DESC", time.Now().UTC().Add(-window))
+
+	return af, err
+}
+
+// GetFailures returns the number of failures of name, 0 when the last one is older
+// than window.
+func GetFailures(kind string, name string, window time.Duration) (int, error) {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var failures int
+
+	err = db.Get(&failures, "SELECT failures FROM auth_failure WHERE kind = ? AND name = ? AND upd_dat >= ?",
+		kind, name, time.Now().UTC().Add(-window))
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return failures, err
+}
+
+// AddFailure counts a failure of name and returns the failures so far. Counting starts
+// over when the last failure is older than window.
+func AddFailure(kind string, name string, window time.Duration) (int, error) {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+
+	_, err = tx.Exec("DELETE FROM auth_failure WHERE kind = ? AND name = ? AND upd_dat < ?", kind, name, now.Add(-window))
+	if err == nil {
+		_, err = tx.Exec("INSERT OR IGNORE INTO auth_failure (kind, name, failures, crt_dat, upd_dat) VALUES (?, ?, 0, ?, ?)", kind, name, now, now)
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE auth_failure SET failures = failures + 1, upd_dat = ? WHERE kind = ? AND name = ?", now, kind, name)
+	}
+
+	var failures int
+	if err == nil {
+		err = tx.Get(&failures, "SELECT failures FROM auth_failure WHERE kind = ? AND name = ?", kind, name)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return failures, tx.Commit()
+}
+
+// ClearFailures forgets the failures of name, of any kind when kind is empty. Returns
+// the number of entries removed.
+func ClearFailures(kind string, name string) (int64, error) {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var res sql.Result
+
+	if kind == "" {
+		res, err = db.Exec("DELETE FROM auth_failure WHERE name = ?", name)
+	} else {
+		res, err = db.Exec("DELETE FROM auth_failure WHERE kind = ? AND name = ?", kind, name)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func ClearAllFailures() (int64, error) {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("DELETE FROM auth_failure")
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
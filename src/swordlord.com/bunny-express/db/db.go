@@ -34,6 +34,7 @@ package db
 import (
 	"github.com/jmoiron/sqlx"
 	"log"
+	"path/filepath"
 	"swordlord.com/bunny-express/common"
 )
 
@@ -62,8 +63,7 @@ CREATE TABLE mailbox (
   CONSTRAINT mailbox_domain_fk FOREIGN KEY (domain) REFERENCES domain (domain)
 );`
 
-// did contain relay_domain varchar(500),
-//
+// relay_domain and an optional pwd_legacy are added by migration 2, see migrate.go
 
 var createAliasTbl = `
 CREATE TABLE alias (
@@ -77,13 +77,20 @@ CREATE TABLE alias (
   CONSTRAINT alias_domain_fk FOREIGN KEY (domain) REFERENCES domain (domain)
 );`
 
+// forward_address is moved to alias_target by migration 6, see migrate.go
+
 var checkTblExists = `SELECT COUNT(name) FROM sqlite_master WHERE type='table' AND tbl_name=?;`
 
-func CheckDatabase() {
+// CheckDatabase prepares the database, returns false when schema migrations are pending.
+func CheckDatabase() bool {
 
-	checkTables()
+	if !checkSchema() {
+		return false
+	}
 
 	checkDemoData()
+
+	return true
 }
 
 func OpenDB() (*sqlx.DB, error) {
@@ -107,6 +114,12 @@ func getDatabaseName() string {
 	}
 }
 
+// absolute path of the database file, for configs of other tools pointing to it
+func GetDatabasePath() (string, error) {
+
+	return filepath.Abs(getDatabaseName())
+}
+
 func checkDemoData() {
 
 	insertDemoData := common.GetBoolFromConfig("db.add_demo_data", false)
@@ -125,7 +138,8 @@ func checkDemoData() {
 	runStatement(db, "INSERT INTO domain (domain, active) VALUES ('demo3.com', true)")
 	runStatement(db, "INSERT INTO mailbox (mail, domain, pwd, mail_dir, local_part) VALUES ('a@demo1.com', 'demo1.com', 'pwd', '/var/mail/', 'demo1.com')")
 	runStatement(db, "INSERT INTO mailbox (mail, domain, pwd, mail_dir, local_part) VALUES ('a@demo2.com', 'demo2.com', 'pwd', '/var/mail/', 'demo2.com')")
-	runStatement(db, "INSERT INTO alias (alias, forward_address, domain) VALUES ('alias@demo2.com', 'a@demo2.com', 'demo2.com')")
+	runStatement(db, "INSERT INTO alias (alias, domain) VALUES ('alias@demo2.com', 'demo2.com')")
+	runStatement(db, "INSERT INTO alias_target (alias, target) VALUES ('alias@demo2.com', 'a@demo2.com')")
 }
 
 func runStatement(db *sqlx.DB, s string) {
@@ -135,47 +149,3 @@ func runStatement(db *sqlx.DB, s string) {
 		common.LogErrorFmt("Could not add Demo Data for statement '%s' with error '%s'", s, err)
 	}
 }
-
-func checkTables() {
-
-	db, err := OpenDB()
-	if err != nil {
-		log.Fatalln(err)
-	}
-	defer db.Close()
-
-	err = checkTable(db, "domain", createDomainTbl)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	err = checkTable(db, "mailbox", createMailboxTbl)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	err = checkTable(db, "alias", createAliasTbl)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-}
-
-func checkTable(db *sqlx.DB, name string, sqlCrt string) error {
-
-	var exists bool
-
-	err := db.QueryRow(checkTblExists, name).Scan(&exists)
-	if err != nil {
-		return err
-	}
-
-	if !exists {
-		_, err := db.Exec(sqlCrt)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
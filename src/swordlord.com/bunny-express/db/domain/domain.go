@@ -35,8 +35,10 @@ package domain
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
+	"math"
 	"strconv"
 	"strings"
 	"swordlord.com/bunny-express/common"
@@ -44,14 +46,36 @@ import (
 	"time"
 )
 
+// how mail for a domain is handled
+const (
+	TypeVirtual  = "virtual"  // mailboxes and aliases, delivered by virtual_transport
+	TypeRelay    = "relay"    // relayed to the transport of the domain
+	TypeBackupMX = "backupmx" // queued as backup MX until the primary one takes it
+	TypeAlias    = "alias"    // aliases only, no mailboxes
+)
+
 type Domain struct {
-	Domain          string         `db:"domain"`
-	Description     sql.NullString `db:"desc"`
-	isDescDirty     bool
-	MailboxCount    int  `db:"mailbox_count"` // dynamically loaded, not stored
-	AliasCount      int  `db:"alias_count"`   // dynamically loaded, not stored
-	IsActive        bool `db:"active"`
-	isIsActiveDirty bool
+	Domain           string         `db:"domain"`
+	Description      sql.NullString `db:"desc"`
+	isDescDirty      bool
+	Type             string `db:"type"`
+	isTypeDirty      bool
+	Transport        sql.NullString `db:"transport"`
+	isTransportDirty bool
+	// limits, 0 means no limit. Quotas are in bytes
+	MaxMailboxes              int `db:"max_mailboxes"`
+	isMaxMailboxesDirty       bool
+	MaxAliases                int `db:"max_aliases"`
+	isMaxAliasesDirty         bool
+	MaxQuotaPerMailbox        int64 `db:"max_quota_per_mailbox"`
+	isMaxQuotaPerMailboxDirty bool
+	MaxTotalQuota             int64 `db:"max_total_quota"`
+	isMaxTotalQuotaDirty      bool
+	MailboxCount              int   `db:"mailbox_count"` // dynamically loaded, not stored
+	AliasCount                int   `db:"alias_count"`   // dynamically loaded, not stored
+	TotalQuota                int64 `db:"-"`             // summed up when loaded, -1 if a quota is no size
+	IsActive                  bool  `db:"active"`
+	isIsActiveDirty           bool
 	// tells us if object is from db or not
 	isNew  bool
 	CrtDat time.Time `db:"crt_dat"`
@@ -63,6 +87,8 @@ func NewDomain() *Domain {
 	d := &Domain{}
 	d.clearDirtyFlags()
 	d.isNew = true
+	d.SetIsActive(true)
+	d.SetType(TypeVirtual)
 	d.MailboxCount = 0
 	d.AliasCount = 0
 	d.CrtDat = time.Now()
@@ -73,13 +99,26 @@ func NewDomain() *Domain {
 
 func (m *Domain) clearDirtyFlags() {
 	m.isDescDirty = false
+	m.isTypeDirty = false
+	m.isTransportDirty = false
+	m.isMaxMailboxesDirty = false
+	m.isMaxAliasesDirty = false
+	m.isMaxQuotaPerMailboxDirty = false
+	m.isMaxTotalQuotaDirty = false
 	m.isIsActiveDirty = false
 }
 
 func (d *Domain) GetDomain() string              { return d.Domain }
 func (d *Domain) GetDescription() sql.NullString { return d.Description }
+func (d *Domain) GetType() string                { return d.Type }
+func (d *Domain) GetTransport() sql.NullString   { return d.Transport }
+func (d *Domain) GetMaxMailboxes() int           { return d.MaxMailboxes }
+func (d *Domain) GetMaxAliases() int             { return d.MaxAliases }
+func (d *Domain) GetMaxQuotaPerMailbox() int64   { return d.MaxQuotaPerMailbox }
+func (d *Domain) GetMaxTotalQuota() int64        { return d.MaxTotalQuota }
 func (d *Domain) GetMailboxCount() int           { return d.MailboxCount }
 func (d *Domain) GetAliasCount() int             { return d.AliasCount }
+func (d *Domain) GetTotalQuota() int64           { return d.TotalQuota }
 func (d *Domain) GetIsActive() bool              { return d.IsActive }
 
 func (d *Domain) SetDomain(domain string) {
@@ -97,6 +136,66 @@ func (d *Domain) SetDescription(description sql.NullString) {
 	d.isDescDirty = true
 }
 
+func (d *Domain) SetType(t string) {
+
+	if d.Type == t {
+		return
+	}
+
+	d.Type = t
+	d.isTypeDirty = true
+}
+
+func (d *Domain) SetTransport(transport sql.NullString) {
+
+	if d.Transport.String == transport.String {
+		return
+	}
+
+	d.Transport = transport
+	d.isTransportDirty = true
+}
+
+func (d *Domain) SetMaxMailboxes(max int) {
+
+	if d.MaxMailboxes == max {
+		return
+	}
+
+	d.MaxMailboxes = max
+	d.isMaxMailboxesDirty = true
+}
+
+func (d *Domain) SetMaxAliases(max int) {
+
+	if d.MaxAliases == max {
+		return
+	}
+
+	d.MaxAliases = max
+	d.isMaxAliasesDirty = true
+}
+
+func (d *Domain) SetMaxQuotaPerMailbox(max int64) {
+
+	if d.MaxQuotaPerMailbox == max {
+		return
+	}
+
+	d.MaxQuotaPerMailbox = max
+	d.isMaxQuotaPerMailboxDirty = true
+}
+
+func (d *Domain) SetMaxTotalQuota(max int64) {
+
+	if d.MaxTotalQuota == max {
+		return
+	}
+
+	d.MaxTotalQuota = max
+	d.isMaxTotalQuotaDirty = true
+}
+
 func (d *Domain) SetIsActive(ia bool) {
 
 	if d.IsActive == ia {
@@ -109,6 +208,12 @@ func (d *Domain) SetIsActive(ia bool) {
 
 func (d *Domain) IsDirty() bool {
 	if d.isDescDirty ||
+		d.isTypeDirty ||
+		d.isTransportDirty ||
+		d.isMaxMailboxesDirty ||
+		d.isMaxAliasesDirty ||
+		d.isMaxQuotaPerMailboxDirty ||
+		d.isMaxTotalQuotaDirty ||
 		d.isIsActiveDirty {
 		return true
 	} else {
@@ -124,11 +229,99 @@ type DomainFilter struct {
 
 func GetFieldCaptions() []string {
 
-	captions := []string{"Domain", "Description", "MailboxCount", "AliasCount", "Active", "Created", "Updated"}
+	captions := []string{"Domain", "Description", "Type", "Transport", "Mailboxes", "Aliases", "QuotaPerMailbox", "TotalQuota", "Active", "Created", "Updated"}
 
 	return captions
 }
 
+func GetTypes() []string {
+
+	return []string{TypeVirtual, TypeRelay, TypeBackupMX, TypeAlias}
+}
+
+func IsValidType(t string) bool {
+
+	for _, vt := range GetTypes() {
+		if t == vt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateTransport checks the form of a transport_maps entry, transport:nexthop with
+// either part optional, e.g. smtp:[host]:25, lmtp:unix:private/dovecot-lmtp or :[host].
+// An empty transport is valid and leaves the default of Postfix.
+func ValidateTransport(transport string) error {
+
+	if transport == "" {
+		return nil
+	}
+
+	if strings.ContainsAny(transport, " \t\r\n,") {
+		return fmt.Errorf("transport '%s' must not contain blanks or commas", transport)
+	}
+
+	i := strings.Index(transport, ":")
+	if i < 0 {
+		return fmt.Errorf("transport '%s' is no transport:nexthop", transport)
+	}
+
+	for _, c := range transport[:i] {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("transport '%s' has an invalid transport name", transport)
+		}
+	}
+
+	return nil
+}
+
+// checks the fields before persisting
+func (d *Domain) validate(db *sqlx.DB) error {
+
+	if !IsValidType(d.Type) {
+		return fmt.Errorf("invalid domain type '%s', use one of %s", d.Type, strings.Join(GetTypes(), ", "))
+	}
+
+	err := ValidateTransport(d.Transport.String)
+	if err != nil {
+		return err
+	}
+
+	if d.MaxMailboxes < 0 || d.MaxAliases < 0 || d.MaxQuotaPerMailbox < 0 || d.MaxTotalQuota < 0 {
+		return errors.New("limits must be 0 or a positive number")
+	}
+
+	// mailboxes are only delivered on virtual domains
+	if d.isTypeDirty && !d.isNew && d.Type != TypeVirtual {
+
+		var count int
+		err = db.Get(&count, "SELECT count(*) FROM mailbox WHERE domain = ?", d.Domain)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("domain %s has %d mailbox(es), only virtual domains can have mailboxes", d.Domain, count)
+		}
+	}
+
+	// alias domains can only point to virtual or alias domains
+	if d.isTypeDirty && !d.isNew && d.Type != TypeVirtual && d.Type != TypeAlias {
+
+		var count int
+		err = db.Get(&count, "SELECT count(*) FROM alias_domain WHERE target_domain = ?", d.Domain)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("domain %s is the target of %d alias domain(s), only virtual or alias domains can be targets", d.Domain, count)
+		}
+	}
+
+	return nil
+}
+
 func GetAllDomains() ([]Domain, error) {
 
 	db, err := db.OpenDB()
@@ -140,8 +333,14 @@ func GetAllDomains() ([]Domain, error) {
 	q := `SELECT 
 			  domain, 
 			  desc,  
+			  type,
+			  transport,
 			  (SELECT count(mail) FROM mailbox WHERE mailbox.domain = domain.domain) as mailbox_count,
 			  (SELECT count(alias) FROM alias WHERE alias.domain = domain.domain) as alias_count,
+			  max_mailboxes,
+			  max_aliases,
+			  max_quota_per_mailbox,
+			  max_total_quota,
 			  active,
 			  crt_dat,
 			  upd_dat
@@ -156,8 +355,11 @@ func GetAllDomains() ([]Domain, error) {
 
 	var d []Domain
 	err = stmt.Select(&d)
+	if err != nil {
+		return nil, err
+	}
 
-	return d, err
+	return d, loadTotalQuotas(db, d)
 }
 
 func GetFilteredDomains(df *DomainFilter) ([]Domain, error) {
@@ -191,8 +393,14 @@ func GetFilteredDomains(df *DomainFilter) ([]Domain, error) {
 	sql := `SELECT 
 			  domain, 
 			  desc,  
+			  type,
+			  transport,
 			  (SELECT count(mail) FROM mailbox WHERE mailbox.domain = domain.domain) as mailbox_count,
 			  (SELECT count(alias) FROM alias WHERE alias.domain = domain.domain) as alias_count,
+			  max_mailboxes,
+			  max_aliases,
+			  max_quota_per_mailbox,
+			  max_total_quota,
 			  active,
 			  crt_dat,
 			  upd_dat
@@ -215,13 +423,47 @@ func GetFilteredDomains(df *DomainFilter) ([]Domain, error) {
 	var d []Domain
 	err = stmt.Select(&d, args...)
 
-	if err == nil {
-		for i := range d {
-			d[i].isNew = false
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range d {
+		d[i].isNew = false
+	}
+
+	return d, loadTotalQuotas(db, d)
+}
+
+// quotas are free text, SQLite would take 1G as 1 byte, so they are summed up here
+func loadTotalQuotas(db *sqlx.DB, domains []Domain) error {
+
+	var quotas []struct {
+		Domain string `db:"domain"`
+		Quota  string `db:"quota"`
+	}
+
+	err := db.Select(&quotas, "SELECT domain, quota FROM mailbox WHERE quota IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	totals := make(map[string]int64)
+
+	for _, q := range quotas {
+
+		bytes, err := ParseQuota(q.Quota)
+		if err != nil || totals[q.Domain] < 0 {
+			totals[q.Domain] = -1
+		} else {
+			totals[q.Domain] += bytes
 		}
 	}
 
-	return d, err
+	for i := range domains {
+		domains[i].TotalQuota = totals[domains[i].Domain]
+	}
+
+	return nil
 }
 
 func GetDomain(domain string) (*Domain, error) {
@@ -242,6 +484,8 @@ func GetDomain(domain string) (*Domain, error) {
 	if err != nil {
 		return NewDomain(), err
 	} else {
+		// the defaults of NewDomain are no changes
+		d.clearDirtyFlags()
 		d.isNew = false
 		return d, nil
 	}
@@ -260,7 +504,21 @@ func (d *Domain) Persist() error {
 		return nil
 	}
 
+	err = d.validate(db)
+	if err != nil {
+		return err
+	}
+
 	if d.isNew {
+		var count int
+		err = db.Get(&count, "SELECT count(*) FROM alias_domain WHERE alias_domain = ?", d.Domain)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%s is an alias domain, delete it first", d.Domain)
+		}
+
 		err = d.add(db)
 	} else {
 		err = d.update(db)
@@ -288,6 +546,54 @@ func (d *Domain) add(db *sqlx.DB) error {
 		params = append(params, d.Description.String)
 	}
 
+	if d.isTypeDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "type"
+		params = append(params, d.Type)
+	}
+
+	if d.isTransportDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "transport"
+		params = append(params, d.Transport.String)
+	}
+
+	if d.isMaxMailboxesDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "max_mailboxes"
+		params = append(params, d.MaxMailboxes)
+	}
+
+	if d.isMaxAliasesDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "max_aliases"
+		params = append(params, d.MaxAliases)
+	}
+
+	if d.isMaxQuotaPerMailboxDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "max_quota_per_mailbox"
+		params = append(params, d.MaxQuotaPerMailbox)
+	}
+
+	if d.isMaxTotalQuotaDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "max_total_quota"
+		params = append(params, d.MaxTotalQuota)
+	}
+
 	if d.isIsActiveDirty {
 		if len(sFields) > 0 {
 			sFields += ", "
@@ -322,7 +628,7 @@ func (d *Domain) add(db *sqlx.DB) error {
 		return err
 	}
 
-	fields := logrus.Fields{"domain": d.Domain, "description": d.Description, "active": d.IsActive}
+	fields := logrus.Fields{"domain": d.Domain, "description": d.Description, "type": d.Type, "transport": d.Transport.String, "active": d.IsActive}
 
 	d.clearDirtyFlags()
 
@@ -354,6 +660,54 @@ func (d *Domain) update(db *sqlx.DB) error {
 		params = append(params, d.Description.String)
 	}
 
+	if d.isTypeDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "type = ?"
+		params = append(params, d.Type)
+	}
+
+	if d.isTransportDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "transport = ?"
+		params = append(params, d.Transport.String)
+	}
+
+	if d.isMaxMailboxesDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "max_mailboxes = ?"
+		params = append(params, d.MaxMailboxes)
+	}
+
+	if d.isMaxAliasesDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "max_aliases = ?"
+		params = append(params, d.MaxAliases)
+	}
+
+	if d.isMaxQuotaPerMailboxDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "max_quota_per_mailbox = ?"
+		params = append(params, d.MaxQuotaPerMailbox)
+	}
+
+	if d.isMaxTotalQuotaDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "max_total_quota = ?"
+		params = append(params, d.MaxTotalQuota)
+	}
+
 	if d.isIsActiveDirty {
 		if len(sStatement) > 0 {
 			sStatement += ", "
@@ -388,7 +742,7 @@ func (d *Domain) update(db *sqlx.DB) error {
 		return err
 	}
 
-	fields := logrus.Fields{"domain": d.Domain, "description": d.Description, "active": d.IsActive}
+	fields := logrus.Fields{"domain": d.Domain, "description": d.Description, "type": d.Type, "transport": d.Transport.String, "active": d.IsActive}
 
 	d.clearDirtyFlags()
 
@@ -482,6 +836,16 @@ func DeleteDomain(name string) error {
 	}
 	defer db.Close()
 
+	// mail to the alias domains would be accepted but not delivered anymore
+	var aliasDomains []string
+	err = db.Select(&aliasDomains, "SELECT alias_domain FROM alias_domain WHERE target_domain = ? ORDER BY alias_domain", name)
+	if err != nil {
+		return err
+	}
+	if len(aliasDomains) > 0 {
+		return fmt.Errorf("domain %s is the target of alias domain %s, delete them first", name, strings.Join(aliasDomains, ", "))
+	}
+
 	stmt, err := db.Preparex(`DELETE FROM domain WHERE domain=?`)
 	if err != nil {
 		return err
@@ -505,3 +869,148 @@ func DeleteDomain(name string) error {
 
 	return nil
 }
+
+// LimitError tells that a mailbox or alias would exceed a limit of its domain.
+type LimitError struct {
+	Domain string
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+
+	return "limit of domain " + e.Domain + " exceeded, " + e.Reason
+}
+
+// CheckMailboxLimits checks the limits of the domain for the mailbox mail with quota in
+// bytes, 0 for none. isAdded tells that the mailbox is new to the domain. The mailbox is
+// left out of the totals, so that changing its quota does not count it twice. Domains
+// which do not exist have no limits.
+func CheckMailboxLimits(db *sqlx.DB, name string, mail string, quota int64, isAdded bool) error {
+
+	d := &Domain{}
+
+	err := db.Get(d, "SELECT * FROM domain WHERE domain = ?", name)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if isAdded && d.MaxMailboxes > 0 {
+
+		var count int
+		err = db.Get(&count, "SELECT count(*) FROM mailbox WHERE domain = ? AND mail <> ?", name, mail)
+		if err != nil {
+			return err
+		}
+
+		if count >= d.MaxMailboxes {
+			return &LimitError{name, fmt.Sprintf("it has %d of %d mailboxes already", count, d.MaxMailboxes)}
+		}
+	}
+
+	if quota <= 0 && (d.MaxQuotaPerMailbox > 0 || d.MaxTotalQuota > 0) {
+		return &LimitError{name, fmt.Sprintf("mailbox %s needs a quota", mail)}
+	}
+
+	if d.MaxQuotaPerMailbox > 0 && quota > d.MaxQuotaPerMailbox {
+		return &LimitError{name, fmt.Sprintf("quota of %d bytes for %s is above the %d bytes allowed per mailbox", quota, mail, d.MaxQuotaPerMailbox)}
+	}
+
+	if d.MaxTotalQuota > 0 {
+
+		total, err := getTotalQuota(db, name, mail)
+		if err != nil {
+			return err
+		}
+
+		if total+quota > d.MaxTotalQuota {
+			return &LimitError{name, fmt.Sprintf("quota of all mailboxes would be %d bytes, %d bytes allowed", total+quota, d.MaxTotalQuota)}
+		}
+	}
+
+	return nil
+}
+
+// sums up the quotas of the mailboxes of the domain but mail. A quota which is no size
+// fails, the total can't be checked without it
+func getTotalQuota(db *sqlx.DB, name string, mail string) (int64, error) {
+
+	var quotas []struct {
+		Mail  string `db:"mail"`
+		Quota string `db:"quota"`
+	}
+
+	err := db.Select(&quotas, "SELECT mail, quota FROM mailbox WHERE domain = ? AND mail <> ? AND quota IS NOT NULL", name, mail)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+
+	for _, q := range quotas {
+
+		bytes, err := ParseQuota(q.Quota)
+		if err != nil {
+			return 0, fmt.Errorf("quota of mailbox %s can't be counted: %s", q.Mail, err)
+		}
+
+		total += bytes
+	}
+
+	return total, nil
+}
+
+// ParseQuota returns the bytes of a quota as stored in mailbox.quota, a number with an
+// optional unit B, K, M, G or T like Dovecot takes it. Empty is 0, no quota.
+func ParseQuota(quota string) (int64, error) {
+
+	value := strings.ToUpper(strings.TrimSpace(quota))
+	if value == "" {
+		return 0, nil
+	}
+
+	units := map[byte]int64{'B': 1, 'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+
+	multiplier := int64(1)
+	if unit, ok := units[value[len(value)-1]]; ok {
+		multiplier = unit
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("quota '%s' is no size, use bytes or a number with unit K, M, G or T", quota)
+	}
+
+	return n * multiplier, nil
+}
+
+// CheckAliasLimits checks if the domain can take one more alias, besides alias itself.
+func CheckAliasLimits(db *sqlx.DB, name string, alias string) error {
+
+	var max int
+
+	err := db.Get(&max, "SELECT max_aliases FROM domain WHERE domain = ?", name)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if max == 0 {
+		return nil
+	}
+
+	var count int
+	err = db.Get(&count, "SELECT count(*) FROM alias WHERE domain = ? AND alias <> ?", name, alias)
+	if err != nil {
+		return err
+	}
+
+	if count >= max {
+		return &LimitError{name, fmt.Sprintf("it has %d of %d aliases already", count, max)}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package domain
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"testing"
+)
+
+func TestParseQuota(t *testing.T) {
+
+	tests := []struct {
+		quota string
+		want  int64
+		ok    bool
+	}{
+		{"", 0, true},
+		{" ", 0, true},
+		{"0", 0, true},
+		{"1000", 1000, true},
+		{"100B", 100, true},
+		{"10k", 10240, true},
+		{"5M", 5 << 20, true},
+		{" 1G ", 1 << 30, true},
+		{"2T", 2 << 40, true},
+		{"1G5", 0, false},
+		{"G", 0, false},
+		{"1.5G", 0, false},
+		{"-1", 0, false},
+		{"1GB", 0, false},
+		{"abc", 0, false},
+		{"9223372036854775807", 9223372036854775807, true},
+		{"9000000000T", 0, false},
+	}
+
+	for _, tt := range tests {
+
+		got, err := ParseQuota(tt.quota)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseQuota(%q) = %d, %v, want %d", tt.quota, got, err, tt.want)
+		}
+	}
+}
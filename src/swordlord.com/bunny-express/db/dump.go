@@ -0,0 +1,468 @@
+package db
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"time"
+)
+
+// Dump is a full copy of all domains, mailboxes and aliases, as written by be dump.
+type Dump struct {
+	SchemaVersion int               `json:"schema_version" yaml:"schema_version"`
+	Created       time.Time         `json:"created" yaml:"created"`
+	Domains       []DumpDomain      `json:"domains" yaml:"domains"`
+	Mailboxen     []DumpMailbox     `json:"mailboxes" yaml:"mailboxes"`
+	Aliases       []DumpAlias       `json:"aliases" yaml:"aliases"`
+	AliasDomains  []DumpAliasDomain `json:"alias_domains,omitempty" yaml:"alias_domains,omitempty"`
+}
+
+type DumpDomain struct {
+	Domain             string    `db:"domain" json:"domain" yaml:"domain"`
+	Description        *string   `db:"desc" json:"description,omitempty" yaml:"description,omitempty"`
+	Type               string    `db:"type" json:"type" yaml:"type"`
+	Transport          *string   `db:"transport" json:"transport,omitempty" yaml:"transport,omitempty"`
+	MaxMailboxes       int       `db:"max_mailboxes" json:"max_mailboxes" yaml:"max_mailboxes"`
+	MaxAliases         int       `db:"max_aliases" json:"max_aliases" yaml:"max_aliases"`
+	MaxQuotaPerMailbox int64     `db:"max_quota_per_mailbox" json:"max_quota_per_mailbox" yaml:"max_quota_per_mailbox"`
+	MaxTotalQuota      int64     `db:"max_total_quota" json:"max_total_quota" yaml:"max_total_quota"`
+	IsActive           bool      `db:"active" json:"active" yaml:"active"`
+	CrtDat             time.Time `db:"crt_dat" json:"created" yaml:"created"`
+	UpdDat             time.Time `db:"upd_dat" json:"updated" yaml:"updated"`
+}
+
+type DumpMailbox struct {
+	Mail           string     `db:"mail" json:"mail" yaml:"mail"`
+	Password       string     `db:"pwd" json:"password" yaml:"password"`
+	PasswordLegacy *string    `db:"pwd_legacy" json:"password_legacy,omitempty" yaml:"password_legacy,omitempty"`
+	Description    *string    `db:"desc" json:"description,omitempty" yaml:"description,omitempty"`
+	LocalPart      string     `db:"local_part" json:"local_part" yaml:"local_part"`
+	Domain         string     `db:"domain" json:"domain" yaml:"domain"`
+	MailDir        string     `db:"mail_dir" json:"mail_dir" yaml:"mail_dir"`
+	RelayDomain    *string    `db:"relay_domain" json:"relay_domain,omitempty" yaml:"relay_domain,omitempty"`
+	Quota          *DumpQuota `db:"quota" json:"quota,omitempty" yaml:"quota,omitempty"`
+	MaxMsgHour     int        `db:"max_msg_hour" json:"max_msg_hour" yaml:"max_msg_hour"`
+	MaxMsgDay      int        `db:"max_msg_day" json:"max_msg_day" yaml:"max_msg_day"`
+	MaxRcptHour    int        `db:"max_rcpt_hour" json:"max_rcpt_hour" yaml:"max_rcpt_hour"`
+	MaxRcptDay     int        `db:"max_rcpt_day" json:"max_rcpt_day" yaml:"max_rcpt_day"`
+	IsActive       bool       `db:"active" json:"active" yaml:"active"`
+	IsSuspended    bool       `db:"suspended" json:"suspended" yaml:"suspended"`
+	CrtDat         time.Time  `db:"crt_dat" json:"created" yaml:"created"`
+	UpdDat         time.Time  `db:"upd_dat" json:"updated" yaml:"updated"`
+}
+
+// DumpQuota keeps the quota of a mailbox as it is stored, which may be free text
+// like 1G. Dumps written before also have plain numbers.
+type DumpQuota string
+
+func (q *DumpQuota) Scan(value interface{}) error {
+
+	var s sql.NullString
+
+	err := s.Scan(value)
+	*q = DumpQuota(s.String)
+
+	return err
+}
+
+func (q *DumpQuota) UnmarshalJSON(data []byte) error {
+
+	var n json.Number
+
+	// a number, or a string that is a number
+	if json.Unmarshal(data, &n) == nil {
+		*q = DumpQuota(n.String())
+		return nil
+	}
+
+	var s string
+
+	err := json.Unmarshal(data, &s)
+	*q = DumpQuota(s)
+
+	return err
+}
+
+type DumpAlias struct {
+	Alias       string   `db:"alias" json:"alias" yaml:"alias"`
+	Description *string  `db:"desc" json:"description,omitempty" yaml:"description,omitempty"`
+	Domain      string   `db:"domain" json:"domain" yaml:"domain"`
+	Targets     []string `db:"-" json:"targets" yaml:"targets"`
+	// blank separated targets of dumps before schema version 6
+	ForwardAddress string    `db:"-" json:"forward_address,omitempty" yaml:"forward_address,omitempty"`
+	IsActive       bool      `db:"active" json:"active" yaml:"active"`
+	CrtDat         time.Time `db:"crt_dat" json:"created" yaml:"created"`
+	UpdDat         time.Time `db:"upd_dat" json:"updated" yaml:"updated"`
+}
+
+type DumpAliasDomain struct {
+	AliasDomain  string    `db:"alias_domain" json:"alias_domain" yaml:"alias_domain"`
+	TargetDomain string    `db:"target_domain" json:"target_domain" yaml:"target_domain"`
+	CrtDat       time.Time `db:"crt_dat" json:"created" yaml:"created"`
+}
+
+type RestoreMode int
+
+const (
+	// fails if a row exists already
+	RestoreNew RestoreMode = iota
+	// rows of the dump overwrite existing ones, others are kept
+	RestoreMerge
+	// all domains, mailboxes and aliases are deleted first
+	RestoreReplace
+)
+
+// the tables in a dump, parents first
+type dumpTable struct {
+	name    string
+	key     string
+	columns []string
+}
+
+var dumpDomainTbl = dumpTable{"domain", "domain", []string{"domain", "desc", "type", "transport", "max_mailboxes", "max_aliases",
+	"max_quota_per_mailbox", "max_total_quota", "active", "crt_dat", "upd_dat"}}
+
+var dumpMailboxTbl = dumpTable{"mailbox", "mail", []string{"mail", "pwd", "pwd_legacy", "desc", "local_part", "domain", "mail_dir",
+	"relay_domain", "quota", "max_msg_hour", "max_msg_day", "max_rcpt_hour", "max_rcpt_day", "active", "suspended", "crt_dat", "upd_dat"}}
+
+var dumpAliasTbl = dumpTable{"alias", "alias", []string{"alias", "desc", "domain", "active", "crt_dat", "upd_dat"}}
+
+var dumpAliasTargetTbl = dumpTable{"alias_target", "alias", []string{"alias", "target"}}
+
+var dumpAliasDomainTbl = dumpTable{"alias_domain", "alias_domain", []string{"alias_domain", "target_domain", "crt_dat"}}
+
+func (t dumpTable) selectStatement() string {
+
+	return "SELECT " + quoteColumns(t.columns, "") + " FROM " + t.name + " ORDER BY " + t.key
+}
+
+func (t dumpTable) insertStatement(mode RestoreMode) string {
+
+	s := "INSERT INTO " + t.name + " (" + quoteColumns(t.columns, "") + ") VALUES (" + quoteColumns(t.columns, ":") + ")"
+
+	if mode == RestoreMerge {
+
+		var set []string
+		for _, c := range t.columns[1:] {
+			set = append(set, `"`+c+`" = excluded."`+c+`"`)
+		}
+
+		s += " ON CONFLICT (" + t.key + ") DO UPDATE SET " + strings.Join(set, ", ")
+	}
+
+	return s
+}
+
+// desc is a keyword, quote them all. With prefix : we get named parameters instead.
+func quoteColumns(columns []string, prefix string) string {
+
+	quoted := make([]string, len(columns))
+
+	for i, c := range columns {
+		if prefix == "" {
+			quoted[i] = `"` + c + `"`
+		} else {
+			quoted[i] = prefix + c
+		}
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+// GetDump reads all domains, mailboxes and aliases.
+func GetDump() (*Dump, error) {
+
+	db, err := OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != GetLatestSchemaVersion() {
+		return nil, fmt.Errorf("database schema is at version %d, run 'be db migrate' first", version)
+	}
+
+	d := &Dump{SchemaVersion: version, Created: time.Now()}
+
+	err = db.Select(&d.Domains, dumpDomainTbl.selectStatement())
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Select(&d.Mailboxen, dumpMailboxTbl.selectStatement())
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Select(&d.Aliases, dumpAliasTbl.selectStatement())
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []struct {
+		Alias  string `db:"alias"`
+		Target string `db:"target"`
+	}
+
+	err = db.Select(&targets, dumpAliasTargetTbl.selectStatement()+", target")
+	if err != nil {
+		return nil, err
+	}
+
+	byAlias := make(map[string][]string)
+	for _, t := range targets {
+		byAlias[t.Alias] = append(byAlias[t.Alias], t.Target)
+	}
+
+	for i := range d.Aliases {
+		d.Aliases[i].Targets = byAlias[d.Aliases[i].Alias]
+	}
+
+	err = db.Select(&d.AliasDomains, dumpAliasDomainTbl.selectStatement())
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Restore writes the dump into the database within one transaction, which is only
+// committed if all mailboxes and aliases have their domain.
+func Restore(d *Dump, mode RestoreMode) error {
+
+	db, err := OpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if version != GetLatestSchemaVersion() {
+		return fmt.Errorf("database schema is at version %d, run 'be db migrate' first", version)
+	}
+
+	if d.SchemaVersion > version {
+		return fmt.Errorf("dump is of schema version %d, this binary only knows version %d", d.SchemaVersion, version)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	err = restore(tx, d, mode)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	common.LogInfo("Dump restored.", logrus.Fields{"domains": len(d.Domains), "mailboxes": len(d.Mailboxen), "aliases": len(d.Aliases), "aliasdomains": len(d.AliasDomains)})
+
+	return nil
+}
+
+func restore(tx *sqlx.Tx, d *Dump, mode RestoreMode) error {
+
+	if mode == RestoreReplace {
+
+		for _, t := range []dumpTable{dumpAliasDomainTbl, dumpAliasTargetTbl, dumpAliasTbl, dumpMailboxTbl, dumpDomainTbl} {
+
+			_, err := tx.Exec("DELETE FROM " + t.name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, dd := range d.Domains {
+
+		// dumps before schema version 8 only know virtual domains
+		if dd.Type == "" {
+			dd.Type = "virtual"
+		}
+
+		err := restoreRow(tx, dumpDomainTbl, mode, dd.Domain, dd)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, dm := range d.Mailboxen {
+		err := restoreRow(tx, dumpMailboxTbl, mode, dm.Mail, dm)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, da := range d.Aliases {
+		err := restoreRow(tx, dumpAliasTbl, mode, da.Alias, da)
+		if err != nil {
+			return err
+		}
+
+		err = restoreTargets(tx, mode, da)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, dad := range d.AliasDomains {
+		err := restoreRow(tx, dumpAliasDomainTbl, mode, dad.AliasDomain, dad)
+		if err != nil {
+			return err
+		}
+	}
+
+	return checkForeignKeys(tx)
+}
+
+func restoreRow(tx *sqlx.Tx, t dumpTable, mode RestoreMode, key string, row interface{}) error {
+
+	_, err := tx.NamedExec(t.insertStatement(mode), row)
+	if err != nil {
+		if mode == RestoreNew && strings.Contains(err.Error(), "UNIQUE") {
+			return fmt.Errorf("%s %s exists already, use merge or replace", t.name, key)
+		}
+		return fmt.Errorf("%s %s: %s", t.name, key, err)
+	}
+
+	return nil
+}
+
+// targets of the alias replace the ones it has
+func restoreTargets(tx *sqlx.Tx, mode RestoreMode, da DumpAlias) error {
+
+	targets := da.Targets
+	if len(targets) == 0 {
+		targets = strings.FieldsFunc(da.ForwardAddress, func(r rune) bool {
+			return r == ' ' || r == ',' || r == '\t'
+		})
+	}
+
+	if mode == RestoreMerge {
+		_, err := tx.Exec("DELETE FROM alias_target WHERE alias = ?", da.Alias)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, t := range targets {
+
+		_, err := tx.Exec("INSERT OR IGNORE INTO alias_target (alias, target) VALUES (?, ?)", da.Alias, t)
+		if err != nil {
+			return fmt.Errorf("alias %s: %s", da.Alias, err)
+		}
+	}
+
+	return nil
+}
+
+// foreign keys are not enforced on every statement, so we check them before committing
+func checkForeignKeys(tx *sqlx.Tx) error {
+
+	type violation struct {
+		table  string
+		rowid  int64
+		parent string
+	}
+
+	var found []violation
+
+	rows, err := tx.Queryx("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+
+		var v violation
+		var fkid int64
+
+		err = rows.Scan(&v.table, &v.rowid, &v.parent, &fkid)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		found = append(found, v)
+	}
+
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	if len(found) == 0 {
+		return nil
+	}
+
+	var violations []string
+
+	for _, v := range found {
+
+		name := fmt.Sprintf("row %d", v.rowid)
+
+		for _, t := range []dumpTable{dumpDomainTbl, dumpMailboxTbl, dumpAliasTbl, dumpAliasTargetTbl, dumpAliasDomainTbl} {
+			if t.name == v.table {
+				tx.Get(&name, "SELECT "+t.key+" FROM "+t.name+" WHERE rowid = ?", v.rowid)
+			}
+		}
+
+		violations = append(violations, fmt.Sprintf("%s %s refers to a missing %s", v.table, name, v.parent))
+	}
+
+	return errors.New("referential integrity violated, nothing restored: " + strings.Join(violations, ", "))
+}
@@ -37,6 +37,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
@@ -45,11 +46,14 @@ import (
 	"strings"
 	"swordlord.com/bunny-express/common"
 	"swordlord.com/bunny-express/db"
+	"swordlord.com/bunny-express/db/aliasdomain"
+	"swordlord.com/bunny-express/db/domain"
 	"time"
 )
 
 const (
-	PW_SALT_BYTES = 4
+	PW_SALT_BYTES      = 4
+	PW_SHA_SALT_LENGTH = 16
 )
 
 type Mailbox struct {
@@ -60,6 +64,8 @@ type Mailbox struct {
 	isDomainDirty      bool
 	Password           string `db:"pwd"`
 	isPasswordDirty    bool
+	PasswordLegacy     sql.NullString `db:"pwd_legacy"`
+	isPwdLegacyDirty   bool
 	MailDir            string `db:"mail_dir"`
 	isMailDirDirty     bool
 	LocalPart          string `db:"local_part"`
@@ -70,6 +76,16 @@ type Mailbox struct {
 	isQuotaDirty       bool
 	IsActive           bool `db:"active"`
 	isIsActiveDirty    bool
+	MaxMsgHour         int `db:"max_msg_hour"`
+	isMaxMsgHourDirty  bool
+	MaxMsgDay          int `db:"max_msg_day"`
+	isMaxMsgDayDirty   bool
+	MaxRcptHour        int `db:"max_rcpt_hour"`
+	isMaxRcptHourDirty bool
+	MaxRcptDay         int `db:"max_rcpt_day"`
+	isMaxRcptDayDirty  bool
+	IsSuspended        bool `db:"suspended"`
+	isIsSuspendedDirty bool
 	// tells us if object is from db or not
 	isNew  bool
 	CrtDat time.Time `db:"crt_dat"`
@@ -93,11 +109,17 @@ func (m *Mailbox) clearDirtyFlags() {
 	m.isDescDirty = false
 	m.isDomainDirty = false
 	m.isPasswordDirty = false
+	m.isPwdLegacyDirty = false
 	m.isMailDirDirty = false
 	m.isLocalPartDirty = false
 	m.isRelayDomainDirty = false
 	m.isQuotaDirty = false
 	m.isIsActiveDirty = false
+	m.isMaxMsgHourDirty = false
+	m.isMaxMsgDayDirty = false
+	m.isMaxRcptHourDirty = false
+	m.isMaxRcptDayDirty = false
+	m.isIsSuspendedDirty = false
 }
 
 func (m *Mailbox) GetMail() string                { return m.Mail }
@@ -109,6 +131,11 @@ func (m *Mailbox) GetLocalPart() string           { return m.LocalPart }
 func (m *Mailbox) GetRelayDomain() sql.NullString { return m.RelayDomain }
 func (m *Mailbox) GetQuota() sql.NullString       { return m.Quota }
 func (m *Mailbox) GetIsActive() bool              { return m.IsActive }
+func (m *Mailbox) GetMaxMsgHour() int             { return m.MaxMsgHour }
+func (m *Mailbox) GetMaxMsgDay() int              { return m.MaxMsgDay }
+func (m *Mailbox) GetMaxRcptHour() int            { return m.MaxRcptHour }
+func (m *Mailbox) GetMaxRcptDay() int             { return m.MaxRcptDay }
+func (m *Mailbox) GetIsSuspended() bool           { return m.IsSuspended }
 
 func (m *Mailbox) SetMail(mail string) {
 	m.Mail = mail
@@ -146,13 +173,34 @@ func (m *Mailbox) SetPassword(password string, scheme string) error {
 	switch scheme {
 	case "BLF-CRYPT":
 		hash, err = common.HashPasswordBCrypt(password)
-	default:
+	case "SHA256-CRYPT", "SHA512-CRYPT":
+		var salt string
+		salt, err = common.GenerateSalt(PW_SHA_SALT_LENGTH)
+		if err != nil {
+			return err
+		}
+		if scheme == "SHA256-CRYPT" {
+			hash, err = common.HashPasswordSHA256Crypt(password, salt, 0)
+		} else {
+			hash, err = common.HashPasswordSHA512Crypt(password, salt, 0)
+		}
+	case "ARGON2I":
+		hash, err = common.HashPasswordArgon2I(password, 0, 0)
+	case "ARGON2ID":
+		hash, err = common.HashPasswordArgon2ID(password, 0, 0)
+	case "CRAM-MD5":
+		hash, err = common.HashPasswordCramMD5(password)
+	case "SCRAM-SHA-256":
+		hash, err = common.HashPasswordScramSHA256(password)
+	case "MD5-CRYPT":
 		salt := make([]byte, PW_SALT_BYTES)
 		_, err = io.ReadFull(rand.Reader, salt)
 		if err != nil {
 			return err
 		}
 		hash, err = common.HashPasswordMD5Crypt(password, hex.EncodeToString(salt))
+	default:
+		return fmt.Errorf("unsupported password scheme %s", scheme)
 	}
 
 	if err != nil {
@@ -165,6 +213,100 @@ func (m *Mailbox) SetPassword(password string, scheme string) error {
 	return nil
 }
 
+// SetPasswordHash takes an already hashed password in the {SCHEME}hash form, as used when
+// migrating accounts. Malformed hashes and unknown schemes are rejected.
+func (m *Mailbox) SetPasswordHash(stored string) error {
+
+	err := common.ValidatePasswordHash(stored)
+	if err != nil {
+		return err
+	}
+
+	scheme, hash, _ := common.SplitSchemeAndHash(stored)
+
+	m.Password = "{" + scheme + "}" + hash
+	m.isPasswordDirty = true
+
+	return nil
+}
+
+// GetAuthMechanisms returns the SASL mechanisms the stored credentials allow.
+func (m *Mailbox) GetAuthMechanisms() []string {
+
+	scheme, _, err := common.SplitSchemeAndHash(m.Password)
+	if err != nil {
+		return nil
+	}
+
+	return common.GetAuthMechanisms(scheme)
+}
+
+// CheckPassword verifies the given password against the stored hash, whatever the scheme.
+func (m *Mailbox) CheckPassword(password string) error {
+	return common.CheckPassword(m.Password, password)
+}
+
+// VerifyPassword is CheckPassword for logins. On success a hash weaker than the configured
+// default scheme is replaced, the old one is kept in pwd_legacy until the next successful
+// login. Failing to rehash is logged, the login stays successful.
+func (m *Mailbox) VerifyPassword(password string) error {
+
+	err := m.CheckPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = m.rehashPassword(password)
+	if err != nil {
+		common.LogError("Could not rehash password.", logrus.Fields{"mail": m.Mail, "error": err})
+	}
+
+	return nil
+}
+
+func (m *Mailbox) rehashPassword(password string) error {
+
+	if !common.GetBoolFromConfig("auth.rehash", true) {
+		return nil
+	}
+
+	scheme, _, err := common.SplitSchemeAndHash(m.Password)
+	if err != nil {
+		return err
+	}
+
+	target := common.GetDefaultScheme()
+
+	if common.IsWeakerScheme(scheme, target) {
+
+		legacy := sql.NullString{String: m.Password, Valid: true}
+
+		err = m.SetPassword(password, target)
+		if err != nil {
+			return err
+		}
+
+		m.SetPasswordLegacy(legacy)
+
+		common.LogInfo("Password rehashed.", logrus.Fields{"mail": m.Mail, "from": scheme, "to": target})
+
+	} else if m.PasswordLegacy.String != "" {
+
+		// second successful login since the rehash, the old hash is not needed anymore
+		m.SetPasswordLegacy(sql.NullString{})
+
+	} else {
+		return nil
+	}
+
+	return m.Persist()
+}
+
+func (m *Mailbox) SetPasswordLegacy(legacy sql.NullString) {
+	m.PasswordLegacy = legacy
+	m.isPwdLegacyDirty = true
+}
+
 func (m *Mailbox) SetMailDir(mailDir string) {
 	m.MailDir = mailDir
 	m.isMailDirDirty = true
@@ -181,11 +323,20 @@ func (m *Mailbox) SetRelayDomain(relayDomain sql.NullString) {
 }
 
 func (m *Mailbox) SetQuota(quota int) {
-	m.Quota.Scan(quota)
-	m.isQuotaDirty = true
+
+	var q sql.NullString
+	q.Scan(quota)
+
+	m.SetQuotaAsNullString(q)
 }
 
+// only a changed quota is dirty, the domain limits are checked on it
 func (m *Mailbox) SetQuotaAsNullString(quota sql.NullString) {
+
+	if m.Quota == quota {
+		return
+	}
+
 	m.Quota = quota
 	m.isQuotaDirty = true
 }
@@ -200,15 +351,54 @@ func (m *Mailbox) SetIsActive(ia bool) {
 	m.isIsActiveDirty = true
 }
 
+// suspended by the auth policy server after too many failed logins
+func (m *Mailbox) SetIsSuspended(is bool) {
+
+	if m.IsSuspended == is {
+		return
+	}
+
+	m.IsSuspended = is
+	m.isIsSuspendedDirty = true
+}
+
+// send limits are enforced by the policy server, 0 means no limit
+
+func (m *Mailbox) SetMaxMsgHour(max int) {
+	m.MaxMsgHour = max
+	m.isMaxMsgHourDirty = true
+}
+
+func (m *Mailbox) SetMaxMsgDay(max int) {
+	m.MaxMsgDay = max
+	m.isMaxMsgDayDirty = true
+}
+
+func (m *Mailbox) SetMaxRcptHour(max int) {
+	m.MaxRcptHour = max
+	m.isMaxRcptHourDirty = true
+}
+
+func (m *Mailbox) SetMaxRcptDay(max int) {
+	m.MaxRcptDay = max
+	m.isMaxRcptDayDirty = true
+}
+
 func (m *Mailbox) IsDirty() bool {
 	if m.isDescDirty ||
 		m.isDomainDirty ||
 		m.isPasswordDirty ||
+		m.isPwdLegacyDirty ||
 		m.isMailDirDirty ||
 		m.isLocalPartDirty ||
 		m.isRelayDomainDirty ||
 		m.isQuotaDirty ||
-		m.isIsActiveDirty {
+		m.isIsActiveDirty ||
+		m.isMaxMsgHourDirty ||
+		m.isMaxMsgDayDirty ||
+		m.isMaxRcptHourDirty ||
+		m.isMaxRcptDayDirty ||
+		m.isIsSuspendedDirty {
 		return true
 	} else {
 		return false
@@ -228,8 +418,8 @@ type MailboxFilter struct {
 
 func GetFieldCaptions() []string {
 
-	captions := []string{"Mail", "Description", "Domain", "Password", "MailDir", "LocalPart",
-		"RelayDomain", "Quota", "Active", "Created", "Updated"}
+	captions := []string{"Mail", "Description", "Domain", "Password", "Mechanisms", "MailDir", "LocalPart",
+		"RelayDomain", "Quota", "SendLimits", "Active", "Suspended", "Created", "Updated"}
 
 	return captions
 }
@@ -343,11 +533,34 @@ func GetMailbox(name string) (*Mailbox, error) {
 	if err != nil {
 		return NewMailbox(), err
 	} else {
+		// the defaults of NewMailbox are no changes
+		m.clearDirtyFlags()
 		m.isNew = false
 		return m, nil
 	}
 }
 
+// GetMailboxByLogin returns the mailbox of login, user@alias-domain is the mailbox
+// user@target-domain. Returns sql.ErrNoRows when there is none.
+func GetMailboxByLogin(login string) (*Mailbox, error) {
+
+	m, err := GetMailbox(login)
+	if err != sql.ErrNoRows {
+		return m, err
+	}
+
+	target, err := aliasdomain.GetTargetAddress(login)
+	if err != nil {
+		return NewMailbox(), err
+	}
+
+	if target == "" {
+		return NewMailbox(), sql.ErrNoRows
+	}
+
+	return GetMailbox(target)
+}
+
 func (m *Mailbox) Persist() error {
 
 	db, err := db.OpenDB()
@@ -361,6 +574,20 @@ func (m *Mailbox) Persist() error {
 		return nil
 	}
 
+	if m.isNew || m.isDomainDirty {
+		err = checkDomainType(db, m.Domain)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.isNew || m.isDomainDirty || m.isQuotaDirty {
+		err = m.checkDomainLimits(db)
+		if err != nil {
+			return err
+		}
+	}
+
 	if m.isNew {
 		err = m.add(db)
 	} else {
@@ -370,6 +597,37 @@ func (m *Mailbox) Persist() error {
 	return err
 }
 
+// mail for relay, backup MX and alias domains is not delivered here, so they can't
+// have mailboxes
+func checkDomainType(db *sqlx.DB, name string) error {
+
+	var domainType string
+
+	err := db.Get(&domainType, "SELECT type FROM domain WHERE domain = ?", name)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if domainType != domain.TypeVirtual {
+		return fmt.Errorf("domain %s is of type %s, mailboxes can only be added to virtual domains", name, domainType)
+	}
+
+	return nil
+}
+
+// an empty quota means no quota, like in the generated configs
+func (m *Mailbox) checkDomainLimits(db *sqlx.DB) error {
+
+	quota, err := domain.ParseQuota(m.Quota.String)
+	if err != nil {
+		return fmt.Errorf("mailbox %s: %s", m.Mail, err)
+	}
+
+	return domain.CheckMailboxLimits(db, m.Domain, m.Mail, quota, m.isNew || m.isDomainDirty)
+}
+
 func (m *Mailbox) add(db *sqlx.DB) error {
 
 	sFields := ""
@@ -404,6 +662,14 @@ func (m *Mailbox) add(db *sqlx.DB) error {
 		params = append(params, m.Password)
 	}
 
+	if m.isPwdLegacyDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "pwd_legacy"
+		params = append(params, m.PasswordLegacy)
+	}
+
 	if m.isMailDirDirty {
 		if len(sFields) > 0 {
 			sFields += ", "
@@ -436,6 +702,54 @@ func (m *Mailbox) add(db *sqlx.DB) error {
 		params = append(params, m.Quota.String)
 	}
 
+	if m.isIsActiveDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "active"
+		params = append(params, m.GetIsActive())
+	}
+
+	if m.isMaxMsgHourDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "max_msg_hour"
+		params = append(params, m.MaxMsgHour)
+	}
+
+	if m.isMaxMsgDayDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "max_msg_day"
+		params = append(params, m.MaxMsgDay)
+	}
+
+	if m.isMaxRcptHourDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "max_rcpt_hour"
+		params = append(params, m.MaxRcptHour)
+	}
+
+	if m.isMaxRcptDayDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "max_rcpt_day"
+		params = append(params, m.MaxRcptDay)
+	}
+
+	if m.isIsSuspendedDirty {
+		if len(sFields) > 0 {
+			sFields += ", "
+		}
+		sFields += "suspended"
+		params = append(params, m.IsSuspended)
+	}
+
 	if len(sFields) > 0 {
 		sFields += ", "
 	}
@@ -507,6 +821,14 @@ func (m *Mailbox) update(db *sqlx.DB) error {
 		params = append(params, m.Password)
 	}
 
+	if m.isPwdLegacyDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "pwd_legacy = ?"
+		params = append(params, m.PasswordLegacy)
+	}
+
 	if m.isMailDirDirty {
 		if len(sStatement) > 0 {
 			sStatement += ", "
@@ -539,6 +861,54 @@ func (m *Mailbox) update(db *sqlx.DB) error {
 		params = append(params, m.Quota.String)
 	}
 
+	if m.isIsActiveDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "active = ?"
+		params = append(params, m.GetIsActive())
+	}
+
+	if m.isMaxMsgHourDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "max_msg_hour = ?"
+		params = append(params, m.MaxMsgHour)
+	}
+
+	if m.isMaxMsgDayDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "max_msg_day = ?"
+		params = append(params, m.MaxMsgDay)
+	}
+
+	if m.isMaxRcptHourDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "max_rcpt_hour = ?"
+		params = append(params, m.MaxRcptHour)
+	}
+
+	if m.isMaxRcptDayDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "max_rcpt_day = ?"
+		params = append(params, m.MaxRcptDay)
+	}
+
+	if m.isIsSuspendedDirty {
+		if len(sStatement) > 0 {
+			sStatement += ", "
+		}
+		sStatement += "suspended = ?"
+		params = append(params, m.IsSuspended)
+	}
+
 	// update upddat field
 	if len(sStatement) > 0 {
 		sStatement += ", "
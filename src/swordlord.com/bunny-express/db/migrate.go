@@ -0,0 +1,436 @@
+package db
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"swordlord.com/bunny-express/common"
+	"time"
+)
+
+var createSchemaVersionTbl = `
+CREATE TABLE IF NOT EXISTS schema_version (
+  version INTEGER PRIMARY KEY,
+  desc varchar(2000),
+  applied_dat timestamp DEFAULT CURRENT_TIMESTAMP
+);`
+
+// relay_domain was read and written by the mailbox object but never part of the table,
+// pwd_legacy was NOT NULL without a default. SQLite can't alter columns, so rebuild.
+var rebuildMailboxTbl = []string{`
+CREATE TABLE mailbox_new (
+  mail varchar(255) PRIMARY KEY,
+  pwd varchar(255) NOT NULL,
+  pwd_legacy varchar(255),
+  desc varchar(2000),
+  local_part varchar(255) NOT NULL,
+  domain varchar(255) NOT NULL,
+  mail_dir varchar(255) NOT NULL,
+  relay_domain varchar(500),
+  quota INTEGER DEFAULT 0,
+  active bool DEFAULT true,
+  crt_dat timestamp DEFAULT CURRENT_TIMESTAMP,
+  upd_dat timestamp DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT mailbox_domain_fk FOREIGN KEY (domain) REFERENCES domain (domain)
+);`,
+	`INSERT INTO mailbox_new (mail, pwd, pwd_legacy, desc, local_part, domain, mail_dir, quota, active, crt_dat, upd_dat)
+  SELECT mail, pwd, pwd_legacy, desc, local_part, domain, mail_dir, quota, active, crt_dat, upd_dat FROM mailbox;`,
+	`DROP TABLE mailbox;`,
+	`ALTER TABLE mailbox_new RENAME TO mailbox;`,
+}
+
+// send limits per mailbox, 0 means no limit. send_log keeps what the policy server let
+// through, for counting against the limits.
+var addSendLimits = []string{
+	`ALTER TABLE mailbox ADD COLUMN max_msg_hour INTEGER DEFAULT 0;`,
+	`ALTER TABLE mailbox ADD COLUMN max_msg_day INTEGER DEFAULT 0;`,
+	`ALTER TABLE mailbox ADD COLUMN max_rcpt_hour INTEGER DEFAULT 0;`,
+	`ALTER TABLE mailbox ADD COLUMN max_rcpt_day INTEGER DEFAULT 0;`,
+	`
+CREATE TABLE send_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  mail varchar(255) NOT NULL,
+  rcpt_count INTEGER DEFAULT 1,
+  crt_dat timestamp DEFAULT CURRENT_TIMESTAMP
+);`,
+	`CREATE INDEX send_log_mail_idx ON send_log (mail, crt_dat);`,
+}
+
+// quota usage as reported by Dovecot through the dict server
+var createQuotaUsageTbl = `
+CREATE TABLE quota_usage (
+  mail varchar(255) PRIMARY KEY,
+  storage INTEGER DEFAULT 0,
+  messages INTEGER DEFAULT 0,
+  upd_dat timestamp DEFAULT CURRENT_TIMESTAMP
+);`
+
+// failed logins per login name and remote IP, counted by the auth policy server
+var addAuthFailures = []string{
+	`ALTER TABLE mailbox ADD COLUMN suspended bool DEFAULT false;`,
+	`
+CREATE TABLE auth_failure (
+  kind varchar(10) NOT NULL,
+  name varchar(255) NOT NULL,
+  failures INTEGER DEFAULT 0,
+  crt_dat timestamp DEFAULT CURRENT_TIMESTAMP,
+  upd_dat timestamp DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (kind, name)
+);`,
+}
+
+// forward addresses of aliases get a row each, the blank or comma separated
+// forward_address is split up and dropped
+var moveAliasTargets = []string{`
+CREATE TABLE alias_target (
+  alias varchar(255) NOT NULL,
+  target varchar(255) NOT NULL,
+  crt_dat timestamp DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (alias, target),
+  CONSTRAINT alias_target_alias_fk FOREIGN KEY (alias) REFERENCES alias (alias)
+);`,
+	`
+INSERT OR IGNORE INTO alias_target (alias, target)
+  WITH RECURSIVE split (alias, target, rest) AS (
+    SELECT alias, '', trim(replace(replace(forward_address, ',', ' '), char(9), ' ')) || ' ' FROM alias
+    UNION ALL
+    SELECT alias, substr(rest, 1, instr(rest, ' ') - 1), ltrim(substr(rest, instr(rest, ' ') + 1)) FROM split WHERE rest <> ''
+  )
+  SELECT alias, target FROM split WHERE target <> '';`,
+	`
+CREATE TABLE alias_new (
+  alias varchar(255) PRIMARY KEY,
+  desc varchar(2000),
+  domain varchar(255) NOT NULL,
+  active bool DEFAULT true,
+  crt_dat timestamp DEFAULT CURRENT_TIMESTAMP,
+  upd_dat timestamp DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT alias_domain_fk FOREIGN KEY (domain) REFERENCES domain (domain)
+);`,
+	`INSERT INTO alias_new (alias, desc, domain, active, crt_dat, upd_dat)
+  SELECT alias, desc, domain, active, crt_dat, upd_dat FROM alias;`,
+	`DROP TABLE alias;`,
+	`ALTER TABLE alias_new RENAME TO alias;`,
+}
+
+// user@alias_domain is delivered to user@target_domain, the alias domain itself is
+// no row in domain
+var createAliasDomainTbl = `
+CREATE TABLE alias_domain (
+  alias_domain varchar(255) PRIMARY KEY,
+  target_domain varchar(255) NOT NULL,
+  crt_dat timestamp DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT alias_domain_target_fk FOREIGN KEY (target_domain) REFERENCES domain (domain)
+);`
+
+// how mail for a domain is handled, see domain.TypeVirtual and friends. transport is
+// the transport_maps entry of the domain, e.g. smtp:[host]:25
+var addDomainTypes = []string{
+	`ALTER TABLE domain ADD COLUMN type varchar(10) NOT NULL DEFAULT 'virtual';`,
+	`ALTER TABLE domain ADD COLUMN transport varchar(255);`,
+}
+
+// limits per domain, 0 means no limit. Quotas are in bytes, like mailbox.quota
+var addDomainLimits = []string{
+	`ALTER TABLE domain ADD COLUMN max_mailboxes INTEGER DEFAULT 0;`,
+	`ALTER TABLE domain ADD COLUMN max_aliases INTEGER DEFAULT 0;`,
+	`ALTER TABLE domain ADD COLUMN max_quota_per_mailbox INTEGER DEFAULT 0;`,
+	`ALTER TABLE domain ADD COLUMN max_total_quota INTEGER DEFAULT 0;`,
+}
+
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// all schema changes in the order they have to be applied. Never change a released
+// migration, append a new one instead.
+var migrations = []migration{
+	{1, "Create domain, mailbox and alias tables", []string{createDomainTbl, createMailboxTbl, createAliasTbl}},
+	{2, "Add relay_domain to mailbox, make pwd_legacy optional", rebuildMailboxTbl},
+	{3, "Add send limits to mailbox, add send_log", addSendLimits},
+	{4, "Add quota_usage", []string{createQuotaUsageTbl}},
+	{5, "Add suspended to mailbox, add auth_failure", addAuthFailures},
+	{6, "Move forward addresses of aliases to alias_target", moveAliasTargets},
+	{7, "Add alias_domain", []string{createAliasDomainTbl}},
+	{8, "Add type and transport to domain", addDomainTypes},
+	{9, "Add mailbox, alias and quota limits to domain", addDomainLimits},
+}
+
+type MigrationState struct {
+	Version     int
+	Description string
+	IsApplied   bool
+	AppliedDat  time.Time
+}
+
+func GetLatestSchemaVersion() int {
+
+	return migrations[len(migrations)-1].version
+}
+
+func GetSchemaVersion() (int, error) {
+
+	db, err := OpenDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	return getSchemaVersion(db)
+}
+
+func GetMigrationStates() ([]MigrationState, error) {
+
+	db, err := OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Queryx("SELECT version, applied_dat FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+
+	for rows.Next() {
+
+		var version int
+		var appliedDat time.Time
+
+		err = rows.Scan(&version, &appliedDat)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedDat
+	}
+
+	var states []MigrationState
+
+	for _, m := range migrations {
+
+		appliedDat, isApplied := applied[m.version]
+		states = append(states, MigrationState{m.version, m.description, isApplied, appliedDat})
+	}
+
+	return states, rows.Err()
+}
+
+// Migrate backs up the database file and applies all pending migrations. Returns the
+// name of the backup file and the number of migrations applied.
+func Migrate() (string, int, error) {
+
+	db, err := OpenDB()
+	if err != nil {
+		return "", 0, err
+	}
+	defer db.Close()
+
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if version >= GetLatestSchemaVersion() {
+		return "", 0, nil
+	}
+
+	backup, err := backupDatabase(version)
+	if err != nil {
+		return "", 0, fmt.Errorf("could not back up database, nothing migrated: %s", err)
+	}
+
+	count, err := applyMigrations(db, version)
+
+	return backup, count, err
+}
+
+// called by CheckDatabase, makes sure we know which schema version we are working with.
+// Returns false if it is outdated.
+func checkSchema() bool {
+
+	db, err := OpenDB()
+	if err != nil {
+		common.LogFatal("Could not open database.", logrus.Fields{"error": err})
+	}
+	defer db.Close()
+
+	_, err = db.Exec(createSchemaVersionTbl)
+	if err != nil {
+		common.LogFatal("Could not create schema_version table.", logrus.Fields{"error": err})
+	}
+
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		common.LogFatal("Could not read schema version.", logrus.Fields{"error": err})
+	}
+
+	if version == 0 {
+
+		exists, err := tableExists(db, "domain")
+		if err != nil {
+			common.LogFatal("Could not check for existing tables.", logrus.Fields{"error": err})
+		}
+
+		if exists {
+			// database was created before we had versions, tables are the ones of migration 1
+			err = recordMigration(db, migrations[0])
+			version = migrations[0].version
+		} else {
+			// fresh database, nothing to back up
+			_, err = applyMigrations(db, 0)
+			version = GetLatestSchemaVersion()
+		}
+
+		if err != nil {
+			common.LogFatal("Could not initialise database schema.", logrus.Fields{"error": err})
+		}
+	}
+
+	if version < GetLatestSchemaVersion() {
+		common.LogWarn("Database schema is outdated, run 'be db migrate' to upgrade.", logrus.Fields{"version": version, "latest": GetLatestSchemaVersion()})
+		return false
+	}
+
+	return true
+}
+
+func getSchemaVersion(db *sqlx.DB) (int, error) {
+
+	var version int
+
+	err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version")
+
+	return version, err
+}
+
+func tableExists(db *sqlx.DB, name string) (bool, error) {
+
+	var exists bool
+
+	err := db.QueryRow(checkTblExists, name).Scan(&exists)
+
+	return exists, err
+}
+
+func recordMigration(db *sqlx.DB, m migration) error {
+
+	_, err := db.Exec("INSERT INTO schema_version (version, desc) VALUES (?, ?)", m.version, m.description)
+
+	return err
+}
+
+// applies every migration newer than given version, each one within its own transaction
+func applyMigrations(db *sqlx.DB, version int) (int, error) {
+
+	count := 0
+
+	for _, m := range migrations {
+
+		if m.version <= version {
+			continue
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return count, err
+		}
+
+		for _, s := range m.statements {
+
+			_, err = tx.Exec(s)
+			if err != nil {
+				tx.Rollback()
+				return count, fmt.Errorf("migration %d failed: %s", m.version, err)
+			}
+		}
+
+		_, err = tx.Exec("INSERT INTO schema_version (version, desc) VALUES (?, ?)", m.version, m.description)
+		if err != nil {
+			tx.Rollback()
+			return count, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return count, err
+		}
+
+		common.LogInfo("Migration applied.", logrus.Fields{"version": m.version, "description": m.description})
+		count++
+	}
+
+	return count, nil
+}
+
+// copies the database file next to the original, named after the schema version it contains
+func backupDatabase(version int) (string, error) {
+
+	src := getDatabaseName()
+	dst := fmt.Sprintf("%s.v%d-%s.bak", src, version, time.Now().Format("20060102-150405"))
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return "", err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return "", err
+	}
+
+	common.LogInfo("Database backed up.", logrus.Fields{"file": dst, "version": version})
+
+	return dst, nil
+}
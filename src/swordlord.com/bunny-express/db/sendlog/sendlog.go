@@ -0,0 +1,87 @@
+package sendlog
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"swordlord.com/bunny-express/db"
+	"time"
+)
+
+// entries older than this are of no use for any limit
+const retention = 24 * time.Hour
+
+type Counts struct {
+	Messages   int `db:"messages"`
+	Recipients int `db:"recipients"`
+}
+
+// AddEntry records a message sent by mail to rcptCount recipients and drops entries
+// which are too old to be counted anymore.
+func AddEntry(mail string, rcptCount int) error {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+
+	_, err = db.Exec("INSERT INTO send_log (mail, rcpt_count, crt_dat) VALUES (?, ?, ?)", mail, rcptCount, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM send_log WHERE crt_dat < ?", now.Add(-retention))
+
+	return err
+}
+
+// GetCounts returns the number of messages and recipients mail sent within the given
+// period up to now.
+func GetCounts(mail string, period time.Duration) (Counts, error) {
+
+	var c Counts
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return c, err
+	}
+	defer db.Close()
+
+	since := time.Now().UTC().Add(-period)
+
+	err = db.Get(&c, "SELECT COUNT(*) AS messages, COALESCE(SUM(rcpt_count), 0) AS recipients FROM send_log WHERE mail = ? AND crt_dat >= ?", mail, since)
+
+	return c, err
+}
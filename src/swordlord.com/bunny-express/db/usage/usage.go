@@ -0,0 +1,147 @@
+package usage
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"swordlord.com/bunny-express/db"
+	"time"
+)
+
+// quota values Dovecot keeps per mailbox
+const (
+	FieldStorage  = "storage"
+	FieldMessages = "messages"
+)
+
+type Usage struct {
+	Mail     string    `db:"mail"`
+	Storage  int64     `db:"storage"`
+	Messages int64     `db:"messages"`
+	UpdDat   time.Time `db:"upd_dat"`
+}
+
+// Change sets a field to Value or, with IsIncrement, adds Value to it.
+type Change struct {
+	Field       string
+	Value       int64
+	IsIncrement bool
+}
+
+func GetFieldCaptions() []string {
+
+	captions := []string{"Mail", "Quota", "Storage", "Messages", "Updated"}
+
+	return captions
+}
+
+func GetUsage(mail string) (*Usage, error) {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	u := &Usage{}
+
+	err = db.Get(u, "SELECT * FROM quota_usage WHERE mail = ?", mail)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// GetFilteredUsage returns the usage of all mailboxes matching mail, which can have
+// wildcards.
+func GetFilteredUsage(mail string) ([]Usage, error) {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if mail == "" {
+		mail = "%"
+	}
+
+	var u []Usage
+
+	err = db.Select(&u, "SELECT * FROM quota_usage WHERE mail LIKE ? ORDER BY mail ASC", mail)
+
+	return u, err
+}
+
+// ApplyChanges applies all changes to the usage of mail within one transaction.
+func ApplyChanges(mail string, changes []Change) error {
+
+	db, err := db.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT OR IGNORE INTO quota_usage (mail) VALUES (?)", mail)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, c := range changes {
+
+		if c.Field != FieldStorage && c.Field != FieldMessages {
+			tx.Rollback()
+			return fmt.Errorf("unknown quota field %s", c.Field)
+		}
+
+		// field is one of the known columns, safe to concatenate
+		if c.IsIncrement {
+			_, err = tx.Exec("UPDATE quota_usage SET "+c.Field+" = "+c.Field+" + ?, upd_dat = ? WHERE mail = ?", c.Value, time.Now(), mail)
+		} else {
+			_, err = tx.Exec("UPDATE quota_usage SET "+c.Field+" = ?, upd_dat = ? WHERE mail = ?", c.Value, time.Now(), mail)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,257 @@
+package dovecot
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/db/authfailure"
+	"swordlord.com/bunny-express/db/mailbox"
+	"time"
+)
+
+// status values of the auth policy API, positive ones are a delay in seconds
+const (
+	AuthPolicyAllow  = 0
+	AuthPolicyReject = -1
+
+	authPolicyMaxRequest = 65536
+)
+
+// fields of auth_policy_request_attributes we use, report requests add the result
+type authPolicyRequest struct {
+	Login        string `json:"login"`
+	Remote       string `json:"remote"`
+	Protocol     string `json:"protocol"`
+	Success      bool   `json:"success"`
+	PolicyReject bool   `json:"policy_reject"`
+}
+
+type authPolicyResponse struct {
+	Status int    `json:"status"`
+	Msg    string `json:"msg"`
+}
+
+// how long failures are remembered
+func GetAuthPolicyWindow() time.Duration {
+
+	window := common.GetIntFromConfigWDefault("authpolicy.window", 3600)
+	if window <= 0 {
+
+		return time.Hour
+	} else {
+
+		return time.Duration(window) * time.Second
+	}
+}
+
+// GetAuthPolicyStatus returns the status for a login or IP with given failures. After
+// authpolicy.free_failures the delay doubles with every failure up to
+// authpolicy.delay_max, authpolicy.reject_after failures are rejected. 0 disables
+// rejecting.
+func GetAuthPolicyStatus(failures int) int {
+
+	free := common.GetIntFromConfigWDefault("authpolicy.free_failures", 3)
+	delayMax := common.GetIntFromConfigWDefault("authpolicy.delay_max", 30)
+	rejectAfter := common.GetIntFromConfigWDefault("authpolicy.reject_after", 20)
+
+	if rejectAfter > 0 && failures >= rejectAfter {
+		return AuthPolicyReject
+	}
+
+	if failures <= free || delayMax <= 0 {
+		return AuthPolicyAllow
+	}
+
+	delay := 1
+	for i := free + 1; i < failures && delay < delayMax; i++ {
+		delay *= 2
+	}
+
+	if delay > delayMax {
+		delay = delayMax
+	}
+
+	return delay
+}
+
+// NewAuthPolicyHandler returns the handler for Dovecot's auth_policy_server_url. Dovecot
+// asks with ?command=allow before and reports with ?command=report after each
+// authentication.
+func NewAuthPolicyHandler() http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req authPolicyRequest
+
+		err := json.NewDecoder(io.LimitReader(r.Body, authPolicyMaxRequest)).Decode(&req)
+		if err != nil {
+			http.Error(w, "malformed request", http.StatusBadRequest)
+			return
+		}
+
+		var resp authPolicyResponse
+
+		switch r.URL.Query().Get("command") {
+		case "allow":
+			resp, err = authPolicyAllow(&req)
+		case "report":
+			resp, err = authPolicyReport(&req)
+		default:
+			http.Error(w, "unknown command", http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			common.LogError("Auth policy request failed.", logrus.Fields{"login": req.Login, "remote": req.Remote, "error": err})
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// decides before authentication, by the failures of the login and the remote IP
+func authPolicyAllow(req *authPolicyRequest) (authPolicyResponse, error) {
+
+	window := GetAuthPolicyWindow()
+
+	if req.Login != "" {
+
+		m, err := mailbox.GetMailboxByLogin(req.Login)
+		if err != nil && err != sql.ErrNoRows {
+			return authPolicyResponse{}, err
+		}
+
+		if err == nil && m.IsSuspended {
+			common.LogInfo("Auth policy rejected suspended mailbox.", logrus.Fields{"login": req.Login, "remote": req.Remote})
+			return authPolicyResponse{AuthPolicyReject, "account suspended"}, nil
+		}
+	}
+
+	loginFailures, err := authfailure.GetFailures(authfailure.KindLogin, req.Login, window)
+	if err != nil {
+		return authPolicyResponse{}, err
+	}
+
+	ipFailures, err := authfailure.GetFailures(authfailure.KindIP, req.Remote, window)
+	if err != nil {
+		return authPolicyResponse{}, err
+	}
+
+	failures := loginFailures
+	if ipFailures > failures {
+		failures = ipFailures
+	}
+
+	status := GetAuthPolicyStatus(failures)
+
+	switch {
+	case status == AuthPolicyReject:
+		common.LogInfo("Auth policy rejected login.", logrus.Fields{"login": req.Login, "remote": req.Remote, "failures": failures})
+		return authPolicyResponse{status, "too many failed logins"}, nil
+	case status > 0:
+		common.LogDebug("Auth policy delayed login.", logrus.Fields{"login": req.Login, "remote": req.Remote, "delay": status})
+	}
+
+	return authPolicyResponse{status, ""}, nil
+}
+
+// counts failed authentications, a success clears the failures of the login
+func authPolicyReport(req *authPolicyRequest) (authPolicyResponse, error) {
+
+	// attempts rejected by us count as well, so that logins which keep failing while
+	// rejected get suspended eventually
+	if req.Success && !req.PolicyReject {
+		_, err := authfailure.ClearFailures(authfailure.KindLogin, req.Login)
+		return authPolicyResponse{}, err
+	}
+
+	window := GetAuthPolicyWindow()
+
+	if req.Remote != "" {
+		_, err := authfailure.AddFailure(authfailure.KindIP, req.Remote, window)
+		if err != nil {
+			return authPolicyResponse{}, err
+		}
+	}
+
+	if req.Login == "" {
+		return authPolicyResponse{}, nil
+	}
+
+	failures, err := authfailure.AddFailure(authfailure.KindLogin, req.Login, window)
+	if err != nil {
+		return authPolicyResponse{}, err
+	}
+
+	common.LogInfo("Auth policy counted failed login.", logrus.Fields{"login": req.Login, "remote": req.Remote, "failures": failures})
+
+	suspendAfter := common.GetIntFromConfigWDefault("authpolicy.suspend_after", 50)
+	if suspendAfter > 0 && failures >= suspendAfter {
+		return authPolicyResponse{}, suspendMailbox(req.Login, failures)
+	}
+
+	return authPolicyResponse{}, nil
+}
+
+func suspendMailbox(login string, failures int) error {
+
+	m, err := mailbox.GetMailboxByLogin(login)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if m.IsSuspended {
+		return nil
+	}
+
+	m.SetIsSuspended(true)
+
+	common.LogWarn("Mailbox suspended after failed logins.", logrus.Fields{"mail": m.Mail, "failures": failures})
+
+	return m.Persist()
+}
@@ -0,0 +1,138 @@
+package dovecot
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/db/domain"
+	"swordlord.com/bunny-express/db/mailbox"
+)
+
+// exit codes of the checkpassword protocol, as interpreted by Dovecot
+const (
+	CheckpasswordFailed   = 1
+	CheckpasswordTempFail = 111
+
+	checkpasswordMaxInput = 512
+)
+
+// CheckpasswordError carries the exit code to end the checkpassword program with.
+type CheckpasswordError struct {
+	ExitCode int
+	Err      error
+}
+
+func (e *CheckpasswordError) Error() string {
+	return e.Err.Error()
+}
+
+// ReadCheckpasswordInput reads username and password, both NUL terminated, as
+// Dovecot writes them to fd 3.
+func ReadCheckpasswordInput(r io.Reader) (string, string, error) {
+
+	input, err := ioutil.ReadAll(io.LimitReader(r, checkpasswordMaxInput))
+	if err != nil {
+		return "", "", err
+	}
+
+	fields := bytes.Split(input, []byte{0})
+	if len(fields) < 2 || len(fields[0]) == 0 {
+		return "", "", errors.New("invalid checkpassword input, expected username\\0password\\0")
+	}
+
+	return string(fields[0]), string(fields[1]), nil
+}
+
+// Checkpassword verifies the credentials read from r against the mailbox table. With
+// authorized set (AUTHORIZED=1, a userdb lookup) the password is not checked. Returns
+// the variables to add to the environment of the reply program.
+func Checkpassword(r io.Reader, authorized bool) ([]string, error) {
+
+	user, password, err := ReadCheckpasswordInput(r)
+	if err != nil {
+		return nil, &CheckpasswordError{CheckpasswordTempFail, err}
+	}
+
+	fields := logrus.Fields{"user": user, "authorized": authorized}
+
+	m, err := mailbox.GetMailboxByLogin(user)
+	if err == sql.ErrNoRows {
+		common.LogInfo("Checkpassword: unknown user.", fields)
+		return nil, &CheckpasswordError{CheckpasswordFailed, errors.New("unknown user")}
+	} else if err != nil {
+		return nil, &CheckpasswordError{CheckpasswordTempFail, err}
+	}
+
+	d, err := domain.GetDomain(m.Domain)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, &CheckpasswordError{CheckpasswordTempFail, err}
+	}
+
+	// a mailbox without its domain is inactive too
+	if !m.IsActive || err == sql.ErrNoRows || !d.IsActive {
+		common.LogInfo("Checkpassword: mailbox or domain inactive.", fields)
+		return nil, &CheckpasswordError{CheckpasswordFailed, errors.New("mailbox or domain inactive")}
+	}
+
+	if !authorized {
+
+		err = m.VerifyPassword(password)
+		if err == common.ErrPasswordMismatch {
+			common.LogInfo("Checkpassword: password mismatch.", fields)
+			return nil, &CheckpasswordError{CheckpasswordFailed, err}
+		} else if err != nil {
+			common.LogError("Checkpassword: could not verify password.", logrus.Fields{"user": user, "error": err})
+			return nil, &CheckpasswordError{CheckpasswordTempFail, err}
+		}
+	}
+
+	env := []string{"USER=" + m.Mail, "HOME=" + GetHome(m)}
+
+	quotaRule := GetQuotaRule(m)
+	if quotaRule != "" {
+		env = append(env, "userdb_quota_rule="+quotaRule, "EXTRA=userdb_quota_rule")
+	}
+
+	if authorized {
+		// tells Dovecot the lookup was done
+		env = append(env, "AUTHORIZED=2")
+	}
+
+	return env, nil
+}
@@ -0,0 +1,145 @@
+package dovecot
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/db"
+	"swordlord.com/bunny-express/db/mailbox"
+)
+
+const sqlConfigFile = "dovecot-sql.conf.ext"
+
+// mailbox.pwd contains the {SCHEME} prefix, Dovecot only falls back to default_pass_scheme without it.
+// Logins of an alias domain find the mailbox of the target domain, user returns its address.
+var sqlConfigTemplate = `# generated by bunnyexpress (be dovecot config), do not edit
+# regenerate after upgrading bunnyexpress
+driver = sqlite
+connect = %[1]s
+default_pass_scheme = %[2]s
+
+password_query = \
+  SELECT mailbox.mail AS user, mailbox.pwd AS password \
+  FROM mailbox JOIN domain ON domain.domain = mailbox.domain \
+  WHERE mailbox.mail IN ('%%u', (SELECT '%%n@' || target_domain FROM alias_domain WHERE alias_domain = '%%d')) \
+    AND mailbox.active = 1 AND domain.active = 1
+
+user_query = \
+  SELECT %[3]s AS home, \
+    CASE WHEN mailbox.quota > 0 THEN '*:bytes=' || mailbox.quota END AS quota_rule \
+  FROM mailbox JOIN domain ON domain.domain = mailbox.domain \
+  WHERE mailbox.mail IN ('%%u', (SELECT '%%n@' || target_domain FROM alias_domain WHERE alias_domain = '%%d')) \
+    AND mailbox.active = 1 AND domain.active = 1
+
+iterate_query = \
+  SELECT mailbox.mail AS user \
+  FROM mailbox JOIN domain ON domain.domain = mailbox.domain \
+  WHERE mailbox.active = 1 AND domain.active = 1
+`
+
+// mail_dir is optional, relative ones are below dovecot.mail_base, same as with
+// virtual_mailbox_base in Postfix
+var homeExpression = `CASE WHEN substr(mailbox.mail_dir, 1, 1) = '/' THEN mailbox.mail_dir \
+      WHEN mailbox.mail_dir <> '' THEN '%[1]s/' || mailbox.mail_dir \
+      ELSE '%[1]s/' || mailbox.domain || '/' || substr(mailbox.mail, 1, instr(mailbox.mail, '@') - 1) END`
+
+func GetMailBase() string {
+
+	base := common.GetStringFromConfig("dovecot.mail_base")
+	if base == "" {
+
+		return "/var/mail/vhosts"
+	} else {
+
+		return strings.TrimRight(base, "/")
+	}
+}
+
+// GetHome returns the home directory of the mailbox, same rules as the user_query.
+func GetHome(m *mailbox.Mailbox) string {
+
+	if strings.HasPrefix(m.MailDir, "/") {
+		return m.MailDir
+	}
+
+	if m.MailDir != "" {
+		return GetMailBase() + "/" + m.MailDir
+	}
+
+	localPart := m.Mail
+	if i := strings.Index(m.Mail, "@"); i >= 0 {
+		localPart = m.Mail[:i]
+	}
+
+	return GetMailBase() + "/" + m.Domain + "/" + localPart
+}
+
+// GetQuotaRule returns the quota of the mailbox as Dovecot quota_rule, empty if unlimited.
+func GetQuotaRule(m *mailbox.Mailbox) string {
+
+	quota := strings.TrimSpace(m.Quota.String)
+	if quota == "" || quota == "0" {
+		return ""
+	}
+
+	return "*:bytes=" + quota
+}
+
+// WriteSQLConfig writes dovecot-sql.conf.ext for the sqlite driver into dir and returns its name.
+func WriteSQLConfig(dir string) (string, error) {
+
+	dbPath, err := db.GetDatabasePath()
+	if err != nil {
+		return "", err
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// the base is used within an SQL string literal
+	base := strings.Replace(GetMailBase(), "'", "''", -1)
+	home := fmt.Sprintf(homeExpression, base)
+
+	file := filepath.Join(dir, sqlConfigFile)
+	content := fmt.Sprintf(sqlConfigTemplate, dbPath, common.GetDefaultScheme(), home)
+
+	err = ioutil.WriteFile(file, []byte(content), 0640)
+
+	return file, err
+}
@@ -0,0 +1,397 @@
+package dovecot
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/db/domain"
+	"swordlord.com/bunny-express/db/mailbox"
+	"swordlord.com/bunny-express/db/usage"
+)
+
+// key prefixes of the dict proxy protocol, shared ones are global, private ones
+// belong to the user of the connection or transaction
+const (
+	dictPrefixPassdb = "shared/passdb/"
+	dictPrefixUserdb = "shared/userdb/"
+	dictPrefixQuota  = "priv/quota/"
+
+	dictMaxLineLength = 65536
+)
+
+var errDictNotFound = errors.New("not found")
+
+// one dict connection, Dovecot sends the hello first
+type dictConnection struct {
+	user         string
+	transactions map[string]*dictTransaction
+}
+
+// quota changes collected until commit
+type dictTransaction struct {
+	user    string
+	changes []usage.Change
+}
+
+// HandleDict speaks the Dovecot dict proxy protocol on conn. Password and user lookups
+// are answered with JSON from the mailbox table, quota updates go to quota_usage.
+func HandleDict(conn net.Conn) {
+
+	c := &dictConnection{transactions: make(map[string]*dictTransaction)}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), dictMaxLineLength)
+
+	for scanner.Scan() {
+
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		reply, err := c.handleCommand(line[0], splitDictArgs(line[1:]))
+		if err != nil {
+			common.LogInfo("Dict connection closed.", logrus.Fields{"error": err})
+			return
+		}
+
+		if reply != "" {
+			_, err = io.WriteString(conn, reply)
+			if err != nil {
+				common.LogDebug("Could not write dict reply.", logrus.Fields{"error": err})
+				return
+			}
+		}
+	}
+
+	if scanner.Err() != nil {
+		common.LogDebug("Dict connection closed.", logrus.Fields{"error": scanner.Err()})
+	}
+}
+
+// returns the reply to send, errors end the connection
+func (c *dictConnection) handleCommand(cmd byte, args []string) (string, error) {
+
+	switch cmd {
+	case 'H':
+		// <major> <minor> <value type> <user> <dict name>
+		if len(args) < 5 || (args[0] != "2" && args[0] != "3") {
+			return "", errors.New("unsupported dict protocol version")
+		}
+		c.user = args[3]
+		return "", nil
+
+	case 'L':
+		// <key> [<user>]
+		if len(args) < 1 {
+			return "", errors.New("malformed lookup")
+		}
+		return c.lookup(args[0], c.userOf(args, 1)), nil
+
+	case 'I':
+		// <flags> [<max rows>] <path> [<user>]
+		return c.iterate(args), nil
+
+	case 'B':
+		// <id> [<user>]
+		if len(args) < 1 {
+			return "", errors.New("malformed begin")
+		}
+		c.transactions[args[0]] = &dictTransaction{user: c.userOf(args, 1)}
+		return "", nil
+
+	case 'S', 'A':
+		// <id> <key> <value>, A adds value to the current one
+		if len(args) < 3 {
+			return "", errors.New("malformed set")
+		}
+		c.addChange(args[0], args[1], args[2], cmd == 'A')
+		return "", nil
+
+	case 'U', 'T':
+		// unset and timestamp, nothing we store
+		return "", nil
+
+	case 'C', 'D':
+		// commit, D asks for an asynchronous reply
+		if len(args) < 1 {
+			return "", errors.New("malformed commit")
+		}
+		reply := c.commit(args[0]) + args[0] + "\n"
+		if cmd == 'D' {
+			reply = "A" + reply
+		}
+		return reply, nil
+
+	case 'R':
+		if len(args) >= 1 {
+			delete(c.transactions, args[0])
+		}
+		return "", nil
+
+	default:
+		return "", errors.New("unknown dict command " + string(cmd))
+	}
+}
+
+// the user given with the command, the one of the hello otherwise
+func (c *dictConnection) userOf(args []string, i int) string {
+
+	if len(args) > i && args[i] != "" {
+		return args[i]
+	}
+
+	return c.user
+}
+
+func (c *dictConnection) lookup(key string, user string) string {
+
+	value, err := lookupDictKey(key, user)
+	switch {
+	case err == nil:
+		return "O" + escapeDictValue(value) + "\n"
+	case err == errDictNotFound:
+		return "N\n"
+	default:
+		common.LogError("Dict lookup failed.", logrus.Fields{"key": key, "error": err})
+		return "F\n"
+	}
+}
+
+func lookupDictKey(key string, user string) (string, error) {
+
+	switch {
+	case strings.HasPrefix(key, dictPrefixPassdb):
+
+		m, err := getActiveMailbox(strings.TrimPrefix(key, dictPrefixPassdb))
+		if err != nil {
+			return "", err
+		}
+		// user changes the login of an alias domain to the one of the mailbox
+		return toDictJSON(map[string]string{"password": m.Password, "user": m.Mail})
+
+	case strings.HasPrefix(key, dictPrefixUserdb):
+
+		m, err := getActiveMailbox(strings.TrimPrefix(key, dictPrefixUserdb))
+		if err != nil {
+			return "", err
+		}
+		return toDictJSON(getUserdbFields(m))
+
+	case strings.HasPrefix(key, dictPrefixQuota):
+
+		u, err := usage.GetUsage(user)
+		if err == sql.ErrNoRows {
+			return "", errDictNotFound
+		} else if err != nil {
+			return "", err
+		}
+
+		switch strings.TrimPrefix(key, dictPrefixQuota) {
+		case usage.FieldStorage:
+			return strconv.FormatInt(u.Storage, 10), nil
+		case usage.FieldMessages:
+			return strconv.FormatInt(u.Messages, 10), nil
+		}
+	}
+
+	return "", errDictNotFound
+}
+
+// only userdb can be iterated, for doveadm -A and the like
+func (c *dictConnection) iterate(args []string) string {
+
+	if len(args) < 2 {
+		return "\n"
+	}
+
+	path := args[1]
+	if len(args) >= 3 {
+		if _, err := strconv.Atoi(args[1]); err == nil {
+			path = args[2]
+		}
+	}
+
+	if path != dictPrefixUserdb {
+		return "\n"
+	}
+
+	mailboxen, err := mailbox.GetAllMailboxen()
+	if err != nil {
+		common.LogError("Dict iteration failed.", logrus.Fields{"path": path, "error": err})
+		return "F\n"
+	}
+
+	var sb strings.Builder
+
+	for i := range mailboxen {
+
+		m, err := getActiveMailbox(mailboxen[i].Mail)
+		if err != nil {
+			continue
+		}
+
+		value, err := toDictJSON(getUserdbFields(m))
+		if err != nil {
+			continue
+		}
+
+		sb.WriteString("O" + escapeDictValue(dictPrefixUserdb+m.Mail) + "\t" + escapeDictValue(value) + "\n")
+	}
+
+	// an empty line ends the iteration
+	sb.WriteString("\n")
+
+	return sb.String()
+}
+
+func (c *dictConnection) addChange(id string, key string, value string, isIncrement bool) {
+
+	t, ok := c.transactions[id]
+	if !ok {
+		return
+	}
+
+	if !strings.HasPrefix(key, dictPrefixQuota) {
+		common.LogWarn("Dict write to unsupported key ignored.", logrus.Fields{"key": key})
+		return
+	}
+
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		common.LogWarn("Dict write with invalid value ignored.", logrus.Fields{"key": key, "value": value})
+		return
+	}
+
+	t.changes = append(t.changes, usage.Change{Field: strings.TrimPrefix(key, dictPrefixQuota), Value: v, IsIncrement: isIncrement})
+}
+
+// returns the reply character, O when committed, F on failure
+func (c *dictConnection) commit(id string) string {
+
+	t, ok := c.transactions[id]
+	delete(c.transactions, id)
+
+	if !ok {
+		return "F"
+	}
+
+	if len(t.changes) == 0 {
+		return "O"
+	}
+
+	if t.user == "" {
+		common.LogError("Dict commit without user.", logrus.Fields{"id": id})
+		return "F"
+	}
+
+	err := usage.ApplyChanges(t.user, t.changes)
+	if err != nil {
+		common.LogError("Dict commit failed.", logrus.Fields{"user": t.user, "error": err})
+		return "F"
+	}
+
+	return "O"
+}
+
+func getUserdbFields(m *mailbox.Mailbox) map[string]string {
+
+	fields := map[string]string{"user": m.Mail, "home": GetHome(m)}
+
+	quotaRule := GetQuotaRule(m)
+	if quotaRule != "" {
+		fields["quota_rule"] = quotaRule
+	}
+
+	return fields
+}
+
+// returns errDictNotFound unless the mailbox and its domain exist and are active
+func getActiveMailbox(mail string) (*mailbox.Mailbox, error) {
+
+	m, err := mailbox.GetMailboxByLogin(mail)
+	if err == sql.ErrNoRows {
+		return nil, errDictNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	d, err := domain.GetDomain(m.Domain)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if !m.IsActive || err == sql.ErrNoRows || !d.IsActive {
+		return nil, errDictNotFound
+	}
+
+	return m, nil
+}
+
+func toDictJSON(fields map[string]string) (string, error) {
+
+	b, err := json.Marshal(fields)
+
+	return string(b), err
+}
+
+// arguments are tab separated, tabs and line breaks within them escaped with \001
+func splitDictArgs(s string) []string {
+
+	args := strings.Split(s, "\t")
+	for i := range args {
+		args[i] = unescapeDictValue(args[i])
+	}
+
+	return args
+}
+
+var dictEscaper = strings.NewReplacer("\001", "\0011", "\t", "\001t", "\r", "\001r", "\n", "\001n", "\000", "\0010")
+var dictUnescaper = strings.NewReplacer("\0011", "\001", "\001t", "\t", "\001r", "\r", "\001n", "\n", "\0010", "\000")
+
+func escapeDictValue(s string) string {
+	return dictEscaper.Replace(s)
+}
+
+func unescapeDictValue(s string) string {
+	return dictUnescaper.Replace(s)
+}
@@ -0,0 +1,133 @@
+package dovecot
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/db/aliasdomain"
+	"swordlord.com/bunny-express/db/domain"
+	"swordlord.com/bunny-express/db/mailbox"
+)
+
+// ExportPasswdFile writes all active mailboxes of active domains to file in Dovecot's
+// passwd-file format. Returns the number of users written. Alias domains get a line per
+// user as well, the user field changes their login to the one of the mailbox.
+func ExportPasswdFile(file string) (int, error) {
+
+	domains, err := domain.GetAllDomains()
+	if err != nil {
+		return 0, err
+	}
+
+	active := make(map[string]bool)
+	for _, d := range domains {
+		active[d.Domain] = d.IsActive
+	}
+
+	mailboxen, err := mailbox.GetAllMailboxen()
+	if err != nil {
+		return 0, err
+	}
+
+	aliasDomains, err := aliasdomain.GetAliasDomains("")
+	if err != nil {
+		return 0, err
+	}
+
+	aliasDomainsOf := make(map[string][]string)
+	for _, ad := range aliasDomains {
+		aliasDomainsOf[ad.TargetDomain] = append(aliasDomainsOf[ad.TargetDomain], ad.AliasDomain)
+	}
+
+	var buf bytes.Buffer
+	count := 0
+
+	buf.WriteString("# generated by bunnyexpress (be export dovecot-passwd), do not edit\n")
+
+	for i := range mailboxen {
+
+		m := &mailboxen[i]
+		if !m.IsActive || !active[m.Domain] {
+			continue
+		}
+
+		line := FormatPasswdLine(m)
+
+		buf.WriteString(line + "\n")
+		count++
+
+		localPart := m.Mail[:strings.LastIndex(m.Mail, "@")+1]
+		for _, ad := range aliasDomainsOf[m.Domain] {
+			buf.WriteString(localPart + ad + strings.TrimPrefix(line, m.Mail) + " user=" + m.Mail + "\n")
+		}
+	}
+
+	// through a temporary file, Dovecot rereads the file when it changes
+	tmp := file + ".tmp"
+
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0640)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.Rename(tmp, file)
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// FormatPasswdLine returns user:{SCHEME}hash:uid:gid::home::extra fields for the mailbox.
+// uid and gid are dovecot.uid and dovecot.gid from the config, empty ones fall back to
+// the settings of Dovecot.
+func FormatPasswdLine(m *mailbox.Mailbox) string {
+
+	home := GetHome(m)
+
+	extra := "userdb_mail=maildir:" + home
+
+	quotaRule := GetQuotaRule(m)
+	if quotaRule != "" {
+		extra += " userdb_quota_rule=" + quotaRule
+	}
+
+	return m.Mail + ":" + m.Password + ":" +
+		common.GetStringFromConfig("dovecot.uid") + ":" + common.GetStringFromConfig("dovecot.gid") + "::" +
+		home + "::" + extra
+}
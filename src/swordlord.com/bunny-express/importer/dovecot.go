@@ -0,0 +1,230 @@
+package importer
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/db/domain"
+	"swordlord.com/bunny-express/db/mailbox"
+	"swordlord.com/bunny-express/dovecot"
+)
+
+// ImportDovecotPasswd reads a Dovecot passwd-file and adds its users as mailboxes,
+// creating missing domains. Users without @ get defaultDomain, or are skipped if it is
+// empty. name is used in the report.
+func ImportDovecotPasswd(r io.Reader, name string, defaultDomain string, dryRun bool) (*Report, error) {
+
+	im, err := newImporter(dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	for scanner.Scan() {
+
+		lineNo++
+		source := name + ":" + strconv.Itoa(lineNo)
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		m, err := parsePasswdLine(line, defaultDomain)
+		if err != nil {
+			im.report.skipped(source, "%s", err)
+			continue
+		}
+
+		err = im.ensureDomain(m.Domain, "imported from "+name)
+		if err != nil {
+			return im.report, err
+		}
+
+		err = im.addMailbox(m, source)
+		if err != nil {
+			return im.report, err
+		}
+	}
+
+	return im.report, scanner.Err()
+}
+
+// user:password:uid:gid:gecos:home:shell:extra fields, uid and gid are not kept
+func parsePasswdLine(line string, defaultDomain string) (*mailbox.Mailbox, error) {
+
+	fields := strings.SplitN(line, ":", 8)
+	if len(fields) < 2 {
+		return nil, errors.New("not a passwd-file line")
+	}
+
+	mail := strings.ToLower(fields[0])
+	if !strings.Contains(mail, "@") {
+		if defaultDomain == "" {
+			return nil, errors.New("user " + mail + " has no domain, use --domain")
+		}
+		mail += "@" + defaultDomain
+	}
+
+	stored, err := getStoredPassword(fields[1])
+	if err != nil {
+		return nil, errors.New("user " + mail + ": " + err.Error())
+	}
+
+	m := mailbox.NewMailbox()
+	m.SetMail(mail)
+	m.SetDomain(mail[strings.LastIndex(mail, "@")+1:])
+	m.SetLocalPart("")
+
+	err = m.SetPasswordHash(stored)
+	if err != nil {
+		return nil, errors.New("user " + mail + ": " + err.Error())
+	}
+
+	home := ""
+	if len(fields) > 5 {
+		home = fields[5]
+	}
+
+	extra := map[string]string{}
+	if len(fields) > 7 {
+		for _, f := range strings.Fields(fields[7]) {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) == 2 {
+				// userdb_ prefixed ones are given by passdb, same for us
+				extra[strings.TrimPrefix(kv[0], "userdb_")] = kv[1]
+			}
+		}
+	}
+
+	if home == "" && strings.HasPrefix(extra["mail"], "maildir:") {
+		home = strings.SplitN(strings.TrimPrefix(extra["mail"], "maildir:"), ":", 2)[0]
+	}
+
+	m.SetMailDir(getMailDir(m, home))
+
+	quota, err := parseQuotaRule(extra["quota_rule"])
+	if err != nil {
+		return nil, errors.New("user " + mail + ": " + err.Error())
+	}
+	m.SetQuota(int(quota))
+
+	m.SetDescription(sql.NullString{String: "imported from passwd-file", Valid: true})
+
+	return m, nil
+}
+
+// returns the password as {SCHEME}hash, crypt hashes without prefix are recognised
+func getStoredPassword(password string) (string, error) {
+
+	if password == "" || strings.HasPrefix(password, "!") || strings.HasPrefix(password, "*") {
+		return "", errors.New("no password or locked")
+	}
+
+	_, _, err := common.SplitSchemeAndHash(password)
+	if err == nil {
+		return password, nil
+	}
+
+	scheme := common.DetectScheme(password)
+	if scheme == "" {
+		return "", errors.New("password hash without {SCHEME} prefix of unknown scheme")
+	}
+
+	return "{" + scheme + "}" + password, nil
+}
+
+// returns mail_dir for home, empty for the default location below dovecot.mail_base
+func getMailDir(m *mailbox.Mailbox, home string) string {
+
+	home = strings.TrimRight(home, "/")
+	if home == "" {
+		return ""
+	}
+
+	// default location of the mailbox
+	if home == dovecot.GetHome(&mailbox.Mailbox{Mail: m.Mail, Domain: m.Domain}) {
+		return ""
+	}
+
+	base := dovecot.GetMailBase() + "/"
+	if strings.HasPrefix(home, base) {
+		return strings.TrimPrefix(home, base)
+	}
+
+	return home
+}
+
+// parses the storage limit of *:bytes=N or *:storage=N (in kilobytes) quota rules,
+// with an optional unit. 0 for no limit.
+func parseQuotaRule(rule string) (int64, error) {
+
+	if rule == "" {
+		return 0, nil
+	}
+
+	for _, part := range strings.Split(rule, ":") {
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || (kv[0] != "bytes" && kv[0] != "storage") {
+			continue
+		}
+
+		value := strings.TrimSpace(kv[1])
+		if value == "" {
+			return 0, errors.New("unsupported quota rule " + rule)
+		}
+
+		// storage is in kilobytes unless a unit is given
+		if kv[0] == "storage" && value[len(value)-1] >= '0' && value[len(value)-1] <= '9' {
+			value += "K"
+		}
+
+		n, err := domain.ParseQuota(value)
+		if err != nil {
+			return 0, errors.New("unsupported quota rule " + rule)
+		}
+
+		return n, nil
+	}
+
+	return 0, errors.New("unsupported quota rule " + rule)
+}
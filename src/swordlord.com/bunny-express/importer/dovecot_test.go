@@ -0,0 +1,121 @@
+package importer
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"testing"
+)
+
+func TestParsePasswdLine(t *testing.T) {
+
+	tests := []struct {
+		line   string
+		domain string
+		mail   string
+		quota  string
+	}{
+		{"u@x.ch:{SHA256-CRYPT}$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "", "u@x.ch", "0"},
+		{"U@X.CH:$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/::::::", "", "u@x.ch", "0"},
+		{"u:$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "x.ch", "u@x.ch", "0"},
+		{"u@x.ch:$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/::::::userdb_quota_rule=*:storage=1M", "", "u@x.ch", "1048576"},
+		{"u@x.ch:$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/::::::quota_rule=*:bytes=100 other=x", "", "u@x.ch", "100"},
+	}
+
+	for _, tt := range tests {
+
+		m, err := parsePasswdLine(tt.line, tt.domain)
+		if err != nil {
+			t.Errorf("parsePasswdLine(%q) = %v", tt.line, err)
+			continue
+		}
+
+		if m.Mail != tt.mail || m.Domain != "x.ch" || m.Quota.String != tt.quota {
+			t.Errorf("parsePasswdLine(%q) = %s %s %s, want %s x.ch %s", tt.line, m.Mail, m.Domain, m.Quota.String, tt.mail, tt.quota)
+		}
+	}
+}
+
+func TestParsePasswdLineMalformed(t *testing.T) {
+
+	tests := []struct {
+		line   string
+		reason string
+	}{
+		{"u@x.ch", "no password field"},
+		{"", "empty line"},
+		{"u:$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "no domain"},
+		{"u@x.ch:", "empty password"},
+		{"u@x.ch:!$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", "locked"},
+		{"u@x.ch:*", "locked"},
+		{"u@x.ch:secret", "plain text"},
+		{"u@x.ch:$y$j9T$abc", "unknown scheme"},
+		{"u@x.ch:{PLAIN}secret", "unsupported scheme"},
+		{"u@x.ch:$1$saltsalt$short", "malformed hash"},
+		{"u@x.ch:$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/::::::quota_rule=*:storage=-1", "negative quota"},
+		{"u@x.ch:$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/::::::quota_rule=*:bytes=1X", "unknown unit"},
+	}
+
+	for _, tt := range tests {
+
+		if _, err := parsePasswdLine(tt.line, ""); err == nil {
+			t.Errorf("parsePasswdLine(%q) accepted, %s", tt.line, tt.reason)
+		}
+	}
+}
+
+func TestParseQuotaRule(t *testing.T) {
+
+	tests := []struct {
+		rule string
+		want int64
+		ok   bool
+	}{
+		{"", 0, true},
+		{"*:bytes=1024", 1024, true},
+		{"*:bytes=10k", 10240, true},
+		{"*:storage=100", 102400, true},
+		{"*:storage=1G", 1 << 30, true},
+		{"*:messages=100", 0, false},
+		{"*:bytes=", 0, false},
+		{"*:bytes=abc", 0, false},
+		{"*:storage=-5M", 0, false},
+	}
+
+	for _, tt := range tests {
+
+		got, err := parseQuotaRule(tt.rule)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseQuotaRule(%q) = %d, %v, want %d", tt.rule, got, err, tt.want)
+		}
+	}
+}
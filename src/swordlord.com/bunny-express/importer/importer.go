@@ -0,0 +1,270 @@
+package importer
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"database/sql"
+	"fmt"
+	"swordlord.com/bunny-express/db/alias"
+	"swordlord.com/bunny-express/db/aliasdomain"
+	"swordlord.com/bunny-express/db/domain"
+	"swordlord.com/bunny-express/db/mailbox"
+)
+
+// Report tells what an import created, and which entries it left out and why.
+type Report struct {
+	DryRun    bool
+	Created   []string
+	Skipped   []string
+	Conflicts []string
+}
+
+func (r *Report) created(format string, a ...interface{}) {
+	r.Created = append(r.Created, fmt.Sprintf(format, a...))
+}
+
+func (r *Report) skipped(source string, format string, a ...interface{}) {
+	r.Skipped = append(r.Skipped, source+": "+fmt.Sprintf(format, a...))
+}
+
+func (r *Report) conflict(source string, format string, a ...interface{}) {
+	r.Conflicts = append(r.Conflicts, source+": "+fmt.Sprintf(format, a...))
+}
+
+// importer adds objects unless they exist already, keeping track of what a dry run
+// would have created
+type importer struct {
+	report       *Report
+	domains      map[string]string // name -> type
+	aliasDomains map[string]bool
+	mailboxen    map[string]bool
+	aliases      map[string]bool
+}
+
+func newImporter(dryRun bool) (*importer, error) {
+
+	im := &importer{
+		report:       &Report{DryRun: dryRun},
+		domains:      make(map[string]string),
+		aliasDomains: make(map[string]bool),
+		mailboxen:    make(map[string]bool),
+		aliases:      make(map[string]bool),
+	}
+
+	domains, err := domain.GetAllDomains()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range domains {
+		im.domains[d.Domain] = d.Type
+	}
+
+	aliasDomains, err := aliasdomain.GetAliasDomains("")
+	if err != nil {
+		return nil, err
+	}
+	for _, ad := range aliasDomains {
+		im.aliasDomains[ad.AliasDomain] = true
+	}
+
+	mailboxen, err := mailbox.GetAllMailboxen()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mailboxen {
+		im.mailboxen[m.Mail] = true
+	}
+
+	aliases, err := alias.GetAllAliases()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range aliases {
+		im.aliases[a.Alias] = true
+	}
+
+	return im, nil
+}
+
+// creates the domain if it does not exist yet
+func (im *importer) ensureDomain(name string, description string) error {
+
+	if im.domains[name] != "" {
+		return nil
+	}
+
+	d := domain.NewDomain()
+	d.SetDomain(name)
+	d.SetDescription(sql.NullString{String: description, Valid: true})
+	d.SetIsActive(true)
+
+	return im.persistDomain(d)
+}
+
+// adds the domain unless it exists
+func (im *importer) addDomain(d *domain.Domain, source string) error {
+
+	if im.domains[d.Domain] != "" {
+		im.report.conflict(source, "domain %s exists already", d.Domain)
+		return nil
+	}
+
+	if im.aliasDomains[d.Domain] {
+		im.report.conflict(source, "domain %s is an alias domain", d.Domain)
+		return nil
+	}
+
+	return im.persistDomain(d)
+}
+
+func (im *importer) persistDomain(d *domain.Domain) error {
+
+	if !im.report.DryRun {
+		err := d.Persist()
+		if err != nil {
+			return err
+		}
+	}
+
+	im.domains[d.Domain] = d.Type
+	im.report.created("domain %s", d.Domain)
+
+	return nil
+}
+
+// adds the mailbox unless it exists, its domain has to exist
+func (im *importer) addMailbox(m *mailbox.Mailbox, source string) error {
+
+	if im.mailboxen[m.Mail] {
+		im.report.conflict(source, "mailbox %s exists already", m.Mail)
+		return nil
+	}
+
+	if im.domains[m.Domain] == "" {
+		im.report.skipped(source, "domain %s of mailbox %s does not exist", m.Domain, m.Mail)
+		return nil
+	}
+
+	if im.domains[m.Domain] != domain.TypeVirtual {
+		im.report.skipped(source, "domain %s of mailbox %s is of type %s, not virtual", m.Domain, m.Mail, im.domains[m.Domain])
+		return nil
+	}
+
+	if !im.report.DryRun {
+		err := m.Persist()
+		if le, ok := err.(*domain.LimitError); ok {
+			im.report.skipped(source, "mailbox %s: %s", m.Mail, le)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	im.mailboxen[m.Mail] = true
+	im.report.created("mailbox %s", m.Mail)
+
+	return nil
+}
+
+// adds the alias unless it or a mailbox with the same address exists, its domain has
+// to exist
+func (im *importer) addAlias(a *alias.Alias, source string) error {
+
+	if im.aliases[a.Alias] {
+		im.report.conflict(source, "alias %s exists already", a.Alias)
+		return nil
+	}
+
+	if im.mailboxen[a.Alias] {
+		im.report.conflict(source, "alias %s has the address of a mailbox", a.Alias)
+		return nil
+	}
+
+	if im.domains[a.Domain] == "" {
+		im.report.skipped(source, "domain %s of alias %s does not exist", a.Domain, a.Alias)
+		return nil
+	}
+
+	if !im.report.DryRun {
+		err := a.Persist()
+		if le, ok := err.(*domain.LimitError); ok {
+			im.report.skipped(source, "alias %s: %s", a.Alias, le)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	im.aliases[a.Alias] = true
+	im.report.created("alias %s", a.Alias)
+
+	return nil
+}
+
+// adds the alias domain unless it or a domain with the same name exists, its target
+// has to be a virtual or alias domain
+func (im *importer) addAliasDomain(name string, target string, source string) error {
+
+	if im.aliasDomains[name] {
+		im.report.conflict(source, "alias domain %s exists already", name)
+		return nil
+	}
+
+	if im.domains[name] != "" {
+		im.report.conflict(source, "alias domain %s is a domain already", name)
+		return nil
+	}
+
+	targetType := im.domains[target]
+	if targetType == "" {
+		im.report.skipped(source, "target domain %s of alias domain %s does not exist", target, name)
+		return nil
+	}
+
+	if targetType != domain.TypeVirtual && targetType != domain.TypeAlias {
+		im.report.skipped(source, "target domain %s of alias domain %s is of type %s, not virtual or alias", target, name, targetType)
+		return nil
+	}
+
+	if !im.report.DryRun {
+		err := aliasdomain.AddAliasDomain(name, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	im.aliasDomains[name] = true
+	im.report.created("alias domain %s", name)
+
+	return nil
+}
@@ -0,0 +1,290 @@
+package importer
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"swordlord.com/bunny-express/db/alias"
+	"swordlord.com/bunny-express/db/domain"
+	"swordlord.com/bunny-express/db/mailbox"
+	"swordlord.com/bunny-express/dovecot"
+)
+
+// ImportPostfixAdmin reads the domain, mailbox, alias and alias_domain tables of a
+// PostfixAdmin MySQL or PostgreSQL dump. name is used in the report.
+func ImportPostfixAdmin(r io.Reader, name string, dryRun bool) (*Report, error) {
+
+	tables, err := parseSQLDump(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if tables["domain"] == nil && tables["mailbox"] == nil && tables["alias"] == nil {
+		return nil, errors.New("no PostfixAdmin tables found in " + name)
+	}
+
+	im, err := newImporter(dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	// PostfixAdmin keeps alias domains in the domain table too, they are only added
+	// as alias domains
+	aliasDomains := make(map[string]bool)
+	if t := tables["alias_domain"]; t != nil {
+		for _, row := range t.rows {
+			aliasDomains[strings.ToLower(t.get(row, "alias_domain"))] = true
+		}
+	}
+
+	// the order matters, mailboxes, aliases and alias domains need their domain
+	steps := []struct {
+		table string
+		add   func(im *importer, t *dumpTable, row []*string, source string) error
+	}{
+		{"domain", importPfaDomain},
+		{"mailbox", importPfaMailbox},
+		{"alias", importPfaAlias},
+		{"alias_domain", importPfaAliasDomain},
+	}
+
+	for _, step := range steps {
+
+		t := tables[step.table]
+		if t == nil {
+			continue
+		}
+
+		for i, row := range t.rows {
+
+			if step.table == "domain" && aliasDomains[strings.ToLower(t.get(row, "domain"))] {
+				continue
+			}
+
+			err = step.add(im, t, row, pfaSource(name, step.table, i))
+			if err != nil {
+				return im.report, err
+			}
+		}
+	}
+
+	return im.report, nil
+}
+
+func importPfaDomain(im *importer, t *dumpTable, row []*string, source string) error {
+
+	name := strings.ToLower(t.get(row, "domain"))
+
+	// pseudo domain of the superadmins
+	if name == "all" {
+		return nil
+	}
+
+	if name == "" {
+		im.report.skipped(source, "no domain name")
+		return nil
+	}
+
+	d := domain.NewDomain()
+	d.SetDomain(name)
+	d.SetDescription(sql.NullString{String: t.get(row, "description"), Valid: true})
+	d.SetIsActive(pfaBool(t.get(row, "active")))
+
+	if pfaBool(t.get(row, "backupmx")) {
+		d.SetType(domain.TypeBackupMX)
+	}
+
+	// "virtual" and the like name the default transport, only transport:nexthop is kept
+	transport := t.get(row, "transport")
+	if strings.Contains(transport, ":") {
+		if domain.ValidateTransport(transport) == nil {
+			d.SetTransport(sql.NullString{String: transport, Valid: true})
+		} else {
+			im.report.skipped(source, "transport %s of domain %s is invalid", transport, name)
+		}
+	}
+
+	return im.addDomain(d, source)
+}
+
+func importPfaMailbox(im *importer, t *dumpTable, row []*string, source string) error {
+
+	mail := strings.ToLower(t.get(row, "username"))
+	if !strings.Contains(mail, "@") {
+		im.report.skipped(source, "mailbox %s has no domain", mail)
+		return nil
+	}
+
+	stored, err := getStoredPassword(t.get(row, "password"))
+	if err != nil {
+		im.report.skipped(source, "mailbox %s: %s", mail, err)
+		return nil
+	}
+
+	m := mailbox.NewMailbox()
+	m.SetMail(mail)
+	m.SetLocalPart("")
+
+	domainName := strings.ToLower(t.get(row, "domain"))
+	if domainName == "" {
+		domainName = mail[strings.LastIndex(mail, "@")+1:]
+	}
+	m.SetDomain(domainName)
+
+	err = m.SetPasswordHash(stored)
+	if err != nil {
+		im.report.skipped(source, "mailbox %s: %s", mail, err)
+		return nil
+	}
+
+	// maildirs of PostfixAdmin are relative to the base of the mail server
+	home := t.get(row, "maildir")
+	if home != "" && !strings.HasPrefix(home, "/") {
+		home = dovecot.GetMailBase() + "/" + home
+	}
+	m.SetMailDir(getMailDir(m, home))
+
+	// in bytes, 0 or negative for no limit
+	quota, err := strconv.ParseInt(t.get(row, "quota"), 10, 64)
+	if err == nil && quota > 0 {
+		m.SetQuota(int(quota))
+	}
+
+	m.SetDescription(sql.NullString{String: t.get(row, "name"), Valid: true})
+	m.SetIsActive(pfaBool(t.get(row, "active")))
+
+	return im.addMailbox(m, source)
+}
+
+func importPfaAlias(im *importer, t *dumpTable, row []*string, source string) error {
+
+	address := strings.ToLower(t.get(row, "address"))
+
+	var forwards []string
+	isOwnMailbox := false
+	hasVacation := false
+
+	for _, fa := range strings.Split(t.get(row, "goto"), ",") {
+
+		fa = strings.ToLower(strings.TrimSpace(fa))
+
+		switch {
+		case fa == "":
+		case fa == address:
+			isOwnMailbox = true
+		case strings.Contains(fa, "@autoreply."):
+			im.report.skipped(source, "vacation of %s is not supported", address)
+			hasVacation = true
+		default:
+			forwards = append(forwards, fa)
+		}
+	}
+
+	// PostfixAdmin keeps an alias to itself for every mailbox
+	if im.mailboxen[address] || isOwnMailbox {
+		if len(forwards) > 0 {
+			im.report.skipped(source, "mailbox %s forwards to %s, forwarding of mailboxes is not supported", address, strings.Join(forwards, " "))
+		}
+		return nil
+	}
+
+	if len(forwards) == 0 {
+		if !hasVacation {
+			im.report.skipped(source, "alias %s has no forward address", address)
+		}
+		return nil
+	}
+
+	domainName := strings.ToLower(t.get(row, "domain"))
+	if domainName == "" {
+		domainName = address[strings.LastIndex(address, "@")+1:]
+	}
+
+	a, err := newPfaAlias(address, domainName, forwards, pfaBool(t.get(row, "active")))
+	if err != nil {
+		im.report.skipped(source, "alias %s: %s", address, err)
+		return nil
+	}
+
+	return im.addAlias(a, source)
+}
+
+func importPfaAliasDomain(im *importer, t *dumpTable, row []*string, source string) error {
+
+	aliasDomain := strings.ToLower(t.get(row, "alias_domain"))
+	targetDomain := strings.ToLower(t.get(row, "target_domain"))
+
+	if aliasDomain == "" || targetDomain == "" {
+		im.report.skipped(source, "alias domain without name or target domain")
+		return nil
+	}
+
+	// alias domains can't be deactivated
+	if !pfaBool(t.get(row, "active")) {
+		im.report.skipped(source, "alias domain %s is inactive", aliasDomain)
+		return nil
+	}
+
+	return im.addAliasDomain(aliasDomain, targetDomain, source)
+}
+
+func newPfaAlias(address string, domainName string, forwards []string, active bool) (*alias.Alias, error) {
+
+	a := alias.NewAlias()
+	a.SetAlias(address)
+	a.SetDomain(domainName)
+	a.SetDescription(sql.NullString{String: "imported from PostfixAdmin", Valid: true})
+	a.SetIsActive(active)
+
+	return a, a.SetTargets(forwards)
+}
+
+func pfaSource(name string, table string, row int) string {
+
+	return name + ":" + table + ":" + strconv.Itoa(row+1)
+}
+
+// MySQL dumps have 1 and 0, PostgreSQL ones t and f
+func pfaBool(value string) bool {
+
+	switch strings.ToLower(value) {
+	case "1", "t", "true", "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
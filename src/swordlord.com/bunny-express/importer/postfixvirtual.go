@@ -0,0 +1,242 @@
+package importer
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/db/alias"
+	"swordlord.com/bunny-express/db/mailbox"
+	"swordlord.com/bunny-express/dovecot"
+)
+
+const (
+	PostfixVirtualAlias   = "alias"
+	PostfixVirtualMailbox = "mailbox"
+)
+
+// one logical line of a postmap source file
+type postmapEntry struct {
+	line  int
+	key   string
+	value string
+}
+
+// ImportPostfixVirtual reads a postmap source file, as used for virtual_alias_maps or
+// virtual_mailbox_maps depending on mapType, and adds its entries as aliases or
+// mailboxes, creating missing domains. name is used in the report.
+func ImportPostfixVirtual(r io.Reader, name string, mapType string, dryRun bool) (*Report, error) {
+
+	if mapType != PostfixVirtualAlias && mapType != PostfixVirtualMailbox {
+		return nil, errors.New("unknown type " + mapType + ", use " + PostfixVirtualAlias + " or " + PostfixVirtualMailbox)
+	}
+
+	entries, err := readPostmapEntries(r)
+	if err != nil {
+		return nil, err
+	}
+
+	im, err := newImporter(dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+
+		source := name + ":" + strconv.Itoa(e.line)
+
+		if e.value == "" {
+			im.report.skipped(source, "key %s has no value", e.key)
+			continue
+		}
+
+		key := strings.ToLower(e.key)
+
+		at := strings.LastIndex(key, "@")
+		if at < 0 {
+			// a domain on its own declares the domain, other keys are local to $myorigin
+			if strings.Contains(key, ".") {
+				err = im.ensureDomain(key, "imported from "+name)
+			} else {
+				im.report.skipped(source, "key %s has no domain", key)
+			}
+
+			if err != nil {
+				return im.report, err
+			}
+			continue
+		}
+
+		domainName := key[at+1:]
+
+		err = im.ensureDomain(domainName, "imported from "+name)
+		if err != nil {
+			return im.report, err
+		}
+
+		if mapType == PostfixVirtualAlias {
+			err = importVirtualAlias(im, key, domainName, e.value, source)
+		} else {
+			err = importVirtualMailbox(im, key, domainName, e.value, source)
+		}
+
+		if err != nil {
+			return im.report, err
+		}
+	}
+
+	return im.report, nil
+}
+
+func importVirtualAlias(im *importer, address string, domainName string, value string, source string) error {
+
+	var forwards []string
+	for _, fa := range alias.ParseTargets(value) {
+		if fa = strings.ToLower(fa); fa != address {
+			forwards = append(forwards, fa)
+		}
+	}
+
+	// mailboxes are often listed as alias to themselves
+	if im.mailboxen[address] {
+		if len(forwards) > 0 {
+			im.report.skipped(source, "mailbox %s forwards to %s, forwarding of mailboxes is not supported", address, strings.Join(forwards, " "))
+		}
+		return nil
+	}
+
+	if len(forwards) == 0 {
+		im.report.skipped(source, "alias %s forwards to itself only", address)
+		return nil
+	}
+
+	a := alias.NewAlias()
+	a.SetAlias(address)
+	a.SetDomain(domainName)
+	a.SetDescription(sql.NullString{String: "imported from virtual map", Valid: true})
+
+	err := a.SetTargets(forwards)
+	if err != nil {
+		im.report.skipped(source, "alias %s: %s", address, err)
+		return nil
+	}
+
+	return im.addAlias(a, source)
+}
+
+// the value is the maildir, relative to virtual_mailbox_base, which should be the
+// same as dovecot.mail_base
+func importVirtualMailbox(im *importer, mail string, domainName string, value string, source string) error {
+
+	if strings.HasPrefix(mail, "@") {
+		im.report.skipped(source, "catch-all mailbox %s is not supported, use an alias", mail)
+		return nil
+	}
+
+	if !strings.HasSuffix(value, "/") {
+		im.report.skipped(source, "mailbox %s is not a maildir, mbox is not supported", mail)
+		return nil
+	}
+
+	m := mailbox.NewMailbox()
+	m.SetMail(mail)
+	m.SetDomain(domainName)
+	m.SetLocalPart("")
+
+	home := value
+	if !strings.HasPrefix(home, "/") {
+		home = dovecot.GetMailBase() + "/" + home
+	}
+	m.SetMailDir(getMailDir(m, home))
+
+	// virtual maps have no passwords, a random one locks the mailbox until it is set
+	password, err := common.GenerateSalt(32)
+	if err != nil {
+		return err
+	}
+
+	err = m.SetPasswordWDefaultScheme(password)
+	if err != nil {
+		return err
+	}
+
+	m.SetDescription(sql.NullString{String: "imported from virtual map, no password set", Valid: true})
+
+	return im.addMailbox(m, source)
+}
+
+// splits a postmap source file into keys and values. Lines starting with whitespace
+// continue the previous one, empty lines and lines starting with # are ignored.
+func readPostmapEntries(r io.Reader) ([]postmapEntry, error) {
+
+	var entries []postmapEntry
+	var current *postmapEntry
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	for scanner.Scan() {
+
+		lineNo++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if current == nil {
+				return nil, errors.New("line " + strconv.Itoa(lineNo) + " continues nothing")
+			}
+			current.value = strings.TrimSpace(current.value + " " + trimmed)
+			continue
+		}
+
+		e := postmapEntry{line: lineNo, key: trimmed}
+		if i := strings.IndexAny(trimmed, " \t"); i >= 0 {
+			e.key = trimmed[:i]
+			e.value = strings.TrimSpace(trimmed[i:])
+		}
+
+		entries = append(entries, e)
+		current = &entries[len(entries)-1]
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,96 @@
+package importer
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadPostmapEntries(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"key and value", "info@x.ch u@x.ch\n", "1:info@x.ch=u@x.ch"},
+		{"tabs and blanks", "info@x.ch\t u@x.ch,  v@x.ch  \n", "1:info@x.ch=u@x.ch,  v@x.ch"},
+		{"comments and empty lines", "# map\n\n  # indented comment\ninfo@x.ch u@x.ch\n", "4:info@x.ch=u@x.ch"},
+		{"continuation", "info@x.ch u@x.ch,\n  v@x.ch\n\tw@x.ch\nx@x.ch y@x.ch", "1:info@x.ch=u@x.ch, v@x.ch w@x.ch|4:x@x.ch=y@x.ch"},
+		{"key without value", "x.ch\n", "1:x.ch="},
+		{"no newline at the end", "@x.ch u@x.ch", "1:@x.ch=u@x.ch"},
+		{"CRLF", "info@x.ch u@x.ch\r\n", "1:info@x.ch=u@x.ch"},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+
+		entries, err := readPostmapEntries(strings.NewReader(tt.input))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		var got []string
+		for _, e := range entries {
+			got = append(got, fmt.Sprintf("%d:%s=%s", e.line, e.key, e.value))
+		}
+
+		if strings.Join(got, "|") != tt.want {
+			t.Errorf("%s: readPostmapEntries = %q, want %q", tt.name, strings.Join(got, "|"), tt.want)
+		}
+	}
+}
+
+func TestReadPostmapEntriesMalformed(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"continuation first", " u@x.ch\n", "line 1 continues nothing"},
+		{"continuation after comment", "# map\n\tu@x.ch\n", "line 2 continues nothing"},
+		{"line too long", "info@x.ch " + strings.Repeat("u", 70000) + "\n", "token too long"},
+	}
+
+	for _, tt := range tests {
+
+		_, err := readPostmapEntries(strings.NewReader(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: readPostmapEntries = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,659 @@
+package importer
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// the rows of one table found in an SQL dump, nil values are NULL
+type dumpTable struct {
+	columns []string
+	rows    [][]*string
+}
+
+// value of column in row, empty if the column or the value is missing
+func (t *dumpTable) get(row []*string, column string) string {
+
+	for i, c := range t.columns {
+		if c == column && i < len(row) && row[i] != nil {
+			return *row[i]
+		}
+	}
+
+	return ""
+}
+
+// parseSQLDump reads the CREATE TABLE, INSERT INTO and COPY ... FROM stdin statements
+// of a MySQL or PostgreSQL dump. Tables are keyed by their name without schema, all
+// other statements are ignored.
+func parseSQLDump(r io.Reader) (map[string]*dumpTable, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := string(b)
+
+	p := &dumpParser{
+		s:      s,
+		tables: make(map[string]*dumpTable),
+		// PostgreSQL takes backslashes in strings literally
+		backslashEscapes: !strings.Contains(s, "standard_conforming_strings = on"),
+	}
+
+	err = p.parse()
+
+	return p.tables, err
+}
+
+type dumpParser struct {
+	s                string
+	pos              int
+	tables           map[string]*dumpTable
+	backslashEscapes bool
+}
+
+const (
+	tokEOF = iota
+	tokWord
+	tokIdent
+	tokString
+	tokPunct
+)
+
+type dumpToken struct {
+	kind int
+	text string
+}
+
+func (p *dumpParser) table(name string) *dumpTable {
+
+	t, ok := p.tables[name]
+	if !ok {
+		t = &dumpTable{}
+		p.tables[name] = t
+	}
+
+	return t
+}
+
+func (p *dumpParser) parse() error {
+
+	for {
+		start := p.pos
+
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case tok.kind == tokEOF:
+			return nil
+
+		// empty statement, like the one after the /*!40101 ... */ of mysqldump
+		case tok.text == ";":
+
+		case isWord(tok, "CREATE"):
+			err = p.parseCreate()
+
+		case isWord(tok, "INSERT"):
+			err = p.parseInsert()
+
+		case isWord(tok, "COPY"):
+			err = p.parseCopy()
+
+		default:
+			err = p.skipStatement()
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s near line %d", err, strings.Count(p.s[:start], "\n")+1)
+		}
+	}
+}
+
+// CREATE TABLE name (column type ..., ..., constraints)
+func (p *dumpParser) parseCreate() error {
+
+	tok, err := p.next()
+	if err != nil || !isWord(tok, "TABLE") {
+		if err == nil {
+			err = p.skipStatement()
+		}
+		return err
+	}
+
+	name, err := p.parseTableName()
+	if err != nil {
+		return err
+	}
+
+	tok, err = p.next()
+	if err != nil {
+		return err
+	}
+	if tok.text != "(" {
+		return p.skipStatement()
+	}
+
+	var columns []string
+	depth := 1
+	first := true
+
+	for depth > 0 {
+
+		tok, err = p.next()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case tok.kind == tokEOF:
+			return errors.New("unexpected end of CREATE TABLE")
+		case tok.text == "(":
+			depth++
+		case tok.text == ")":
+			depth--
+		case tok.text == "," && depth == 1:
+			first = true
+			continue
+		case first && depth == 1:
+			if tok.kind == tokIdent || (tok.kind == tokWord && !isConstraintWord(tok.text)) {
+				columns = append(columns, strings.ToLower(tok.text))
+			}
+		}
+
+		first = false
+	}
+
+	p.table(name).columns = columns
+
+	return p.skipStatement()
+}
+
+// INSERT [IGNORE] INTO name [(columns)] VALUES (...), (...)
+func (p *dumpParser) parseInsert() error {
+
+	tok, err := p.next()
+	for err == nil && !isWord(tok, "INTO") && tok.kind != tokEOF {
+		tok, err = p.next()
+	}
+	if err != nil {
+		return err
+	}
+
+	name, err := p.parseTableName()
+	if err != nil {
+		return err
+	}
+
+	t := p.table(name)
+
+	tok, err = p.next()
+	if err != nil {
+		return err
+	}
+
+	if tok.text == "(" {
+
+		columns, err := p.parseColumnList()
+		if err != nil {
+			return err
+		}
+		t.columns = columns
+
+		tok, err = p.next()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !isWord(tok, "VALUES") {
+		return p.skipStatement()
+	}
+
+	for {
+		tok, err = p.next()
+		if err != nil {
+			return err
+		}
+		if tok.text != "(" {
+			break
+		}
+
+		row, err := p.parseTuple()
+		if err != nil {
+			return err
+		}
+		t.rows = append(t.rows, row)
+
+		tok, err = p.next()
+		if err != nil {
+			return err
+		}
+		if tok.text != "," {
+			break
+		}
+	}
+
+	if tok.text == ";" || tok.kind == tokEOF {
+		return nil
+	}
+
+	return p.skipStatement()
+}
+
+// COPY name (columns) FROM stdin; followed by tab separated rows up to \.
+func (p *dumpParser) parseCopy() error {
+
+	name, err := p.parseTableName()
+	if err != nil {
+		return err
+	}
+
+	tok, err := p.next()
+	if err != nil {
+		return err
+	}
+	if tok.text != "(" {
+		return p.skipStatement()
+	}
+
+	columns, err := p.parseColumnList()
+	if err != nil {
+		return err
+	}
+
+	tok, err = p.next()
+	if err != nil || !isWord(tok, "FROM") {
+		if err == nil {
+			err = p.skipStatement()
+		}
+		return err
+	}
+
+	tok, err = p.next()
+	if err != nil || !isWord(tok, "STDIN") {
+		if err == nil {
+			err = p.skipStatement()
+		}
+		return err
+	}
+
+	err = p.skipStatement()
+	if err != nil {
+		return err
+	}
+
+	// data starts on the next line
+	if nl := strings.IndexByte(p.s[p.pos:], '\n'); nl >= 0 {
+		p.pos += nl + 1
+	}
+
+	t := p.table(name)
+	t.columns = columns
+
+	for p.pos < len(p.s) {
+
+		end := strings.IndexByte(p.s[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.s) - p.pos
+		}
+
+		line := strings.TrimSuffix(p.s[p.pos:p.pos+end], "\r")
+		p.pos += end + 1
+
+		if line == "\\." {
+			return nil
+		}
+
+		var row []*string
+		for _, field := range strings.Split(line, "\t") {
+			if field == "\\N" {
+				row = append(row, nil)
+			} else {
+				v := unescapeCopyField(field)
+				row = append(row, &v)
+			}
+		}
+
+		t.rows = append(t.rows, row)
+	}
+
+	return errors.New("COPY data without end marker")
+}
+
+func (p *dumpParser) parseTableName() (string, error) {
+
+	tok, err := p.next()
+	if err != nil {
+		return "", err
+	}
+
+	// IF NOT EXISTS
+	if isWord(tok, "IF") {
+		p.next()
+		p.next()
+		tok, err = p.next()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// ONLY of PostgreSQL
+	if isWord(tok, "ONLY") {
+		tok, err = p.next()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	name := tok.text
+
+	// schema.table, we only want the table
+	for {
+		save := p.pos
+
+		dot, err := p.next()
+		if err != nil {
+			return "", err
+		}
+		if dot.text != "." {
+			p.pos = save
+			break
+		}
+
+		tok, err = p.next()
+		if err != nil {
+			return "", err
+		}
+		name = tok.text
+	}
+
+	return strings.ToLower(name), nil
+}
+
+// column names up to the closing parenthesis
+func (p *dumpParser) parseColumnList() ([]string, error) {
+
+	var columns []string
+
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case tok.kind == tokEOF:
+			return nil, errors.New("unexpected end of column list")
+		case tok.text == ")":
+			return columns, nil
+		case tok.text == ",":
+		default:
+			columns = append(columns, strings.ToLower(tok.text))
+		}
+	}
+}
+
+// values up to the closing parenthesis. Casts like '1'::integer and signs are handled,
+// anything else is taken as text.
+func (p *dumpParser) parseTuple() ([]*string, error) {
+
+	var row []*string
+	var tokens []dumpToken
+	depth := 0
+
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if tok.kind == tokEOF {
+			return nil, errors.New("unexpected end of values")
+		}
+
+		if depth == 0 && (tok.text == "," || tok.text == ")") {
+
+			row = append(row, tupleValue(tokens))
+			tokens = nil
+
+			if tok.text == ")" {
+				return row, nil
+			}
+			continue
+		}
+
+		if tok.text == "(" {
+			depth++
+		} else if tok.text == ")" {
+			depth--
+		}
+
+		tokens = append(tokens, tok)
+	}
+}
+
+func tupleValue(tokens []dumpToken) *string {
+
+	if len(tokens) == 0 || isWord(tokens[0], "NULL") {
+		return nil
+	}
+
+	// E'...' strings of PostgreSQL
+	if len(tokens) > 1 && isWord(tokens[0], "E") && tokens[1].kind == tokString {
+		tokens = tokens[1:]
+	}
+
+	if tokens[0].kind == tokString {
+		return &tokens[0].text
+	}
+
+	v := ""
+	for _, t := range tokens {
+		if t.text == ":" {
+			break
+		}
+		v += t.text
+	}
+
+	return &v
+}
+
+// skips up to and including the ; ending the current statement
+func (p *dumpParser) skipStatement() error {
+
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+
+		if tok.kind == tokEOF || tok.text == ";" {
+			return nil
+		}
+	}
+}
+
+// next token, comments and whitespace are skipped
+func (p *dumpParser) next() (dumpToken, error) {
+
+	for p.pos < len(p.s) {
+
+		c := p.s[p.pos]
+		rest := p.s[p.pos:]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+
+		case strings.HasPrefix(rest, "--") || c == '#':
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				p.pos = len(p.s)
+			} else {
+				p.pos += end + 1
+			}
+
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				return dumpToken{}, errors.New("unterminated comment")
+			}
+			p.pos += end + 4
+
+		case c == '\'':
+			return p.readString()
+
+		case c == '`' || c == '"':
+			end := strings.IndexByte(rest[1:], c)
+			if end < 0 {
+				return dumpToken{}, errors.New("unterminated identifier")
+			}
+			p.pos += end + 2
+			return dumpToken{tokIdent, rest[1 : end+1]}, nil
+
+		case isWordChar(c):
+			end := 1
+			for end < len(rest) && isWordChar(rest[end]) {
+				end++
+			}
+			p.pos += end
+			return dumpToken{tokWord, rest[:end]}, nil
+
+		default:
+			p.pos++
+			return dumpToken{tokPunct, string(c)}, nil
+		}
+	}
+
+	return dumpToken{tokEOF, ""}, nil
+}
+
+func (p *dumpParser) readString() (dumpToken, error) {
+
+	var sb strings.Builder
+
+	for i := p.pos + 1; i < len(p.s); i++ {
+
+		c := p.s[i]
+
+		switch {
+		case c == '\'' && i+1 < len(p.s) && p.s[i+1] == '\'':
+			sb.WriteByte('\'')
+			i++
+
+		case c == '\'':
+			p.pos = i + 1
+			return dumpToken{tokString, sb.String()}, nil
+
+		case c == '\\' && p.backslashEscapes && i+1 < len(p.s):
+			i++
+			sb.WriteString(unescapeChar(p.s[i]))
+
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return dumpToken{}, errors.New("unterminated string")
+}
+
+func unescapeChar(c byte) string {
+
+	switch c {
+	case '0':
+		return "\000"
+	case 'b':
+		return "\b"
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case 'Z':
+		return "\032"
+	default:
+		return string(c)
+	}
+}
+
+func unescapeCopyField(field string) string {
+
+	if !strings.Contains(field, "\\") {
+		return field
+	}
+
+	var sb strings.Builder
+
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+1 < len(field) {
+			i++
+			sb.WriteString(unescapeChar(field[i]))
+		} else {
+			sb.WriteByte(field[i])
+		}
+	}
+
+	return sb.String()
+}
+
+func isWordChar(c byte) bool {
+
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '$' || c == '-' || c == '+'
+}
+
+func isWord(tok dumpToken, word string) bool {
+
+	return tok.kind == tokWord && strings.EqualFold(tok.text, word)
+}
+
+// table elements of CREATE TABLE which are not columns
+func isConstraintWord(word string) bool {
+
+	switch strings.ToUpper(word) {
+	case "PRIMARY", "KEY", "UNIQUE", "INDEX", "CONSTRAINT", "FOREIGN", "FULLTEXT", "CHECK", "SPATIAL":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,160 @@
+package importer
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSQLDump(t *testing.T) {
+
+	mysql := "-- MySQL dump\n/*!40101 SET NAMES utf8 */;\n" +
+		"CREATE TABLE `mailbox` (\n  `username` varchar(255) NOT NULL,\n  `password` varchar(255) NOT NULL DEFAULT '',\n" +
+		"  `quota` bigint(20) NOT NULL DEFAULT '0',\n  PRIMARY KEY (`username`),\n  KEY `domain` (`username`)\n) ENGINE=InnoDB;\n" +
+		"INSERT INTO `mailbox` VALUES ('u@x.ch','$1$a\\'b',-1),('v@x.ch','it''s',NULL);\n"
+
+	postgres := "SET standard_conforming_strings = on;\n" +
+		"COPY public.alias (address, goto, active) FROM stdin;\n" +
+		"info@x.ch\tu@x.ch,v@x.ch\tt\n" +
+		"tab@x.ch\ta\\tb\t\\N\n" +
+		"\\.\n" +
+		"INSERT INTO public.domain (domain, quota) VALUES (E'x.ch', '5'::bigint);\n" +
+		"INSERT INTO ONLY domain (domain, quota) VALUES ('back\\slash.ch', 0);\n"
+
+	tests := []struct {
+		name   string
+		input  string
+		table  string
+		column string
+		want   []string
+	}{
+		{"mysql columns from CREATE TABLE", mysql, "mailbox", "username", []string{"u@x.ch", "v@x.ch"}},
+		{"mysql escapes", mysql, "mailbox", "password", []string{"$1$a'b", "it's"}},
+		{"mysql negative and NULL", mysql, "mailbox", "quota", []string{"-1", "<nil>"}},
+		{"postgres COPY", postgres, "alias", "goto", []string{"u@x.ch,v@x.ch", "a\tb"}},
+		{"postgres COPY NULL", postgres, "alias", "active", []string{"t", "<nil>"}},
+		{"postgres INSERT with schema and casts", postgres, "domain", "quota", []string{"5", "0"}},
+		{"postgres backslashes are literal", postgres, "domain", "domain", []string{"x.ch", "back\\slash.ch"}},
+	}
+
+	for _, tt := range tests {
+
+		tables, err := parseSQLDump(strings.NewReader(tt.input))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		dt := tables[tt.table]
+		if dt == nil {
+			t.Errorf("%s: table %s not found", tt.name, tt.table)
+			continue
+		}
+
+		var got []string
+		for _, row := range dt.rows {
+			i := indexOf(dt.columns, tt.column)
+			if i < 0 || i >= len(row) || row[i] == nil {
+				got = append(got, "<nil>")
+			} else {
+				got = append(got, *row[i])
+			}
+		}
+
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: %s.%s = %q, want %q", tt.name, tt.table, tt.column, got, tt.want)
+		}
+	}
+}
+
+func TestParseSQLDumpMalformed(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"unterminated string", "INSERT INTO domain VALUES ('x.ch);\n", "unterminated string near line 1"},
+		{"unterminated comment", "SELECT 1;\n/* no end\n", "unterminated comment"},
+		{"unterminated identifier", "INSERT INTO `domain VALUES ('x.ch');", "unterminated identifier"},
+		{"unterminated values", "INSERT INTO domain VALUES ('x.ch', 1", "unexpected end of values"},
+		{"unterminated column list", "INSERT INTO domain (domain, active", "unexpected end of column list"},
+		{"unterminated CREATE TABLE", "CREATE TABLE domain (domain varchar(255)", "unexpected end of CREATE TABLE"},
+		{"COPY without end marker", "COPY domain (domain) FROM stdin;\nx.ch\n", "COPY data without end marker"},
+	}
+
+	for _, tt := range tests {
+
+		_, err := parseSQLDump(strings.NewReader(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: parseSQLDump = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// statements which are not understood are skipped, not taken as rows
+func TestParseSQLDumpIgnored(t *testing.T) {
+
+	input := "LOCK TABLES `domain` WRITE;\n" +
+		"INSERT INTO domain SELECT * FROM other;\n" +
+		"CREATE INDEX idx ON domain (domain);\n" +
+		"COPY domain (domain) TO stdout;\n" +
+		"INSERT INTO domain (domain) VALUES ('x.ch');\n"
+
+	tables, err := parseSQLDump(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dt := tables["domain"]
+	if dt == nil || len(dt.rows) != 1 || dt.get(dt.rows[0], "domain") != "x.ch" {
+		t.Errorf("domain = %+v, want the single row x.ch", dt)
+	}
+
+	// a missing column and a short row give empty values
+	if dt != nil && len(dt.rows) == 1 && (dt.get(dt.rows[0], "active") != "" || dt.get(nil, "domain") != "") {
+		t.Errorf("missing values are not empty")
+	}
+}
+
+func indexOf(columns []string, column string) int {
+
+	for i, c := range columns {
+		if c == column {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,164 @@
+package postfix
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"swordlord.com/bunny-express/db"
+)
+
+// Postfix quotes %s itself. Lines starting with whitespace continue the previous line.
+// Alias type domains and alias domains have no mailboxes, virtual_alias_maps rewrites
+// their addresses.
+var virtualMailboxDomainsQuery = `SELECT domain FROM domain
+  WHERE domain = '%s' AND active = 1 AND type IN ('virtual', 'alias')
+  UNION SELECT alias_domain.alias_domain
+  FROM alias_domain JOIN domain ON domain.domain = alias_domain.target_domain
+  WHERE alias_domain.alias_domain = '%s' AND domain.active = 1 AND domain.type IN ('virtual', 'alias')`
+
+var relayDomainsQuery = `SELECT domain FROM domain
+  WHERE domain = '%s' AND active = 1 AND type IN ('relay', 'backupmx')`
+
+// Postfix asks for the address first, then for its domain
+var transportMapsQuery = `SELECT transport FROM domain
+  WHERE domain = '%s' AND active = 1 AND transport <> ''`
+
+var virtualMailboxMapsQuery = `SELECT CASE WHEN mailbox.mail_dir <> '' THEN mailbox.mail_dir
+    ELSE mailbox.domain || '/' || substr(mailbox.mail, 1, instr(mailbox.mail, '@') - 1) || '/' END
+  FROM mailbox JOIN domain ON domain.domain = mailbox.domain
+  WHERE mailbox.mail = '%s' AND mailbox.active = 1 AND domain.active = 1`
+
+// first match wins: the mailbox itself, so that a catch-all does not take its mail,
+// then the alias of the address, then the catch-all of its domain. Addresses of an
+// alias domain are rewritten to the target domain, as long as the target address is
+// known there, so unknown recipients are still rejected.
+var virtualAliasMapsQuery = `SELECT target FROM (
+    SELECT 1 AS step, mailbox.mail AS target
+    FROM mailbox JOIN domain ON domain.domain = mailbox.domain
+    WHERE mailbox.mail = '%s' AND mailbox.active = 1 AND domain.active = 1
+  UNION ALL
+    SELECT 2, group_concat(alias_target.target, ',')
+    FROM alias JOIN domain ON domain.domain = alias.domain
+      JOIN alias_target ON alias_target.alias = alias.alias
+    WHERE alias.alias = '%s' AND alias.active = 1 AND domain.active = 1
+    GROUP BY alias.alias
+  UNION ALL
+    SELECT 3, group_concat(alias_target.target, ',')
+    FROM alias JOIN domain ON domain.domain = alias.domain
+      JOIN alias_target ON alias_target.alias = alias.alias
+    WHERE instr('%s', '@') > 1 AND alias.alias = substr('%s', instr('%s', '@'))
+      AND alias.active = 1 AND domain.active = 1
+    GROUP BY alias.alias
+  UNION ALL
+    SELECT 4, substr('%s', 1, instr('%s', '@')) || alias_domain.target_domain
+    FROM alias_domain JOIN domain ON domain.domain = alias_domain.target_domain
+    WHERE instr('%s', '@') > 1 AND alias_domain.alias_domain = substr('%s', instr('%s', '@') + 1)
+      AND domain.active = 1
+      AND (EXISTS (SELECT 1 FROM mailbox WHERE mailbox.active = 1
+          AND mailbox.mail = substr('%s', 1, instr('%s', '@')) || alias_domain.target_domain)
+        OR EXISTS (SELECT 1 FROM alias WHERE alias.active = 1
+          AND alias.alias IN (substr('%s', 1, instr('%s', '@')) || alias_domain.target_domain, '@' || alias_domain.target_domain))))
+  ORDER BY step LIMIT 1`
+
+var senderLoginMapsQuery = `SELECT mailbox.mail
+  FROM mailbox JOIN domain ON domain.domain = mailbox.domain
+  WHERE mailbox.mail = '%s' AND mailbox.active = 1 AND domain.active = 1
+  UNION SELECT alias_target.target
+  FROM alias JOIN domain ON domain.domain = alias.domain
+    JOIN alias_target ON alias_target.alias = alias.alias
+  WHERE alias.alias = '%s' AND alias.active = 1 AND domain.active = 1
+  UNION SELECT mailbox.mail
+  FROM alias_domain JOIN domain ON domain.domain = alias_domain.target_domain
+    JOIN mailbox ON mailbox.mail = substr('%s', 1, instr('%s', '@')) || alias_domain.target_domain
+  WHERE alias_domain.alias_domain = substr('%s', instr('%s', '@') + 1)
+    AND mailbox.active = 1 AND domain.active = 1
+  UNION SELECT alias_target.target
+  FROM alias_domain JOIN domain ON domain.domain = alias_domain.target_domain
+    JOIN alias ON alias.alias = substr('%s', 1, instr('%s', '@')) || alias_domain.target_domain
+    JOIN alias_target ON alias_target.alias = alias.alias
+  WHERE alias_domain.alias_domain = substr('%s', instr('%s', '@') + 1)
+    AND alias.active = 1 AND domain.active = 1`
+
+var lookupConfigTemplate = `# generated by bunnyexpress (be postfix config), do not edit
+# regenerate after upgrading bunnyexpress
+dbpath = %s
+query = %s
+`
+
+type LookupConfig struct {
+	Parameter string // main.cf parameter this lookup table is meant for
+	Query     string
+}
+
+var lookupConfigs = []LookupConfig{
+	{"virtual_mailbox_domains", virtualMailboxDomainsQuery},
+	{"virtual_mailbox_maps", virtualMailboxMapsQuery},
+	{"virtual_alias_maps", virtualAliasMapsQuery},
+	{"smtpd_sender_login_maps", senderLoginMapsQuery},
+	{"relay_domains", relayDomainsQuery},
+	{"transport_maps", transportMapsQuery},
+}
+
+// WriteLookupConfigs writes one sqlite lookup table config per parameter into dir.
+// Returns the main.cf lines pointing to the written files.
+func WriteLookupConfigs(dir string) ([]string, error) {
+
+	dbPath, err := db.GetDatabasePath()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var mainCf []string
+
+	for _, lc := range lookupConfigs {
+
+		file := filepath.Join(dir, lc.Parameter+".cf")
+		content := fmt.Sprintf(lookupConfigTemplate, dbPath, lc.Query)
+
+		err = ioutil.WriteFile(file, []byte(content), 0640)
+		if err != nil {
+			return mainCf, err
+		}
+
+		mainCf = append(mainCf, lc.Parameter+" = sqlite:"+file)
+	}
+
+	return mainCf, nil
+}
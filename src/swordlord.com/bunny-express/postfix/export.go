@@ -0,0 +1,359 @@
+package postfix
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/db/alias"
+	"swordlord.com/bunny-express/db/aliasdomain"
+	"swordlord.com/bunny-express/db/domain"
+	"swordlord.com/bunny-express/db/mailbox"
+)
+
+// file names of the exported maps
+const (
+	ExportVirtualDomains   = "virtual_domains"
+	ExportVirtualMailboxes = "virtual_mailboxes"
+	ExportVirtualAliases   = "virtual_aliases"
+	ExportSenderLoginMaps  = "sender_login_maps"
+	ExportRelayDomains     = "relay_domains"
+	ExportTransportMaps    = "transport"
+)
+
+var exportNames = []string{ExportVirtualDomains, ExportVirtualMailboxes, ExportVirtualAliases, ExportSenderLoginMaps,
+	ExportRelayDomains, ExportTransportMaps}
+
+var exportHeader = "# generated by bunnyexpress (be export postfix), do not edit\n"
+
+// ExportMaps writes the lookup tables as postmap source files into dir, sorted by key.
+// Files whose content did not change are left alone. Returns the files written.
+func ExportMaps(dir string) ([]string, error) {
+
+	maps, err := buildExportMaps()
+	if err != nil {
+		return nil, err
+	}
+
+	var written []string
+
+	for _, name := range exportNames {
+
+		file := filepath.Join(dir, name)
+
+		changed, err := writeIfChanged(file, formatExportMap(maps[name]))
+		if err != nil {
+			return written, err
+		}
+
+		if changed {
+			written = append(written, file)
+		}
+	}
+
+	return written, nil
+}
+
+// GetOutdatedMaps returns the exported files in dir without a compiled map next to
+// them, or with one older than the file. This is the case when postmap failed before.
+func GetOutdatedMaps(dir string) ([]string, error) {
+
+	var outdated []string
+
+	for _, name := range exportNames {
+
+		file := filepath.Join(dir, name)
+
+		source, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+
+		// file.lmdb, file.db, file.cdb and the like, depending on the map type
+		compiled, err := filepath.Glob(file + ".*")
+		if err != nil {
+			return nil, err
+		}
+
+		isOutdated := true
+
+		for _, c := range compiled {
+
+			if strings.HasSuffix(c, ".tmp") {
+				continue
+			}
+
+			fi, err := os.Stat(c)
+			if err == nil && !fi.ModTime().Before(source.ModTime()) {
+				isOutdated = false
+			}
+		}
+
+		if isOutdated {
+			outdated = append(outdated, file)
+		}
+	}
+
+	return outdated, nil
+}
+
+// GetPostmapCommand returns postfix.postmap_command from the config, empty if none.
+// %s is replaced with the file, e.g. "postmap lmdb:%s".
+func GetPostmapCommand() string {
+
+	return common.GetStringFromConfig("postfix.postmap_command")
+}
+
+// RunPostmap runs the configured postmap command for each file.
+func RunPostmap(files []string) error {
+
+	command := GetPostmapCommand()
+	if command == "" {
+		return nil
+	}
+
+	for _, file := range files {
+
+		args := strings.Fields(command)
+		hasFile := false
+
+		for i := range args {
+			if strings.Contains(args[i], "%s") {
+				args[i] = strings.Replace(args[i], "%s", file, -1)
+				hasFile = true
+			}
+		}
+
+		if !hasFile {
+			args = append(args, file)
+		}
+
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s failed: %s %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	return nil
+}
+
+// builds key -> value per map, with the same rules as Lookup
+func buildExportMaps() (map[string]map[string]string, error) {
+
+	domains, err := domain.GetAllDomains()
+	if err != nil {
+		return nil, err
+	}
+
+	mailboxen, err := mailbox.GetAllMailboxen()
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := alias.GetAllAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	aliasDomains, err := aliasdomain.GetAliasDomains("")
+	if err != nil {
+		return nil, err
+	}
+
+	maps := map[string]map[string]string{
+		ExportVirtualDomains:   {},
+		ExportVirtualMailboxes: {},
+		ExportVirtualAliases:   {},
+		ExportSenderLoginMaps:  {},
+		ExportRelayDomains:     {},
+		ExportTransportMaps:    {},
+	}
+
+	active := make(map[string]bool)
+	local := make(map[string]bool)
+
+	for _, d := range domains {
+
+		if !d.IsActive {
+			continue
+		}
+
+		active[d.Domain] = true
+
+		if isLocalType(d.Type) {
+			local[d.Domain] = true
+			maps[ExportVirtualDomains][d.Domain] = "OK"
+		} else {
+			maps[ExportRelayDomains][d.Domain] = "OK"
+		}
+
+		if d.Transport.String != "" {
+			maps[ExportTransportMaps][d.Domain] = d.Transport.String
+		}
+	}
+
+	for i := range mailboxen {
+
+		m := &mailboxen[i]
+		if !m.IsActive || !active[m.Domain] {
+			continue
+		}
+
+		maps[ExportVirtualMailboxes][m.Mail] = GetMailboxPath(m)
+		// Postfix asks for the address before @domain, so the mailbox comes first, then
+		// its alias, then the catch-all
+		maps[ExportVirtualAliases][m.Mail] = m.Mail
+		maps[ExportSenderLoginMaps][m.Mail] = m.Mail
+	}
+
+	for i := range aliases {
+
+		a := &aliases[i]
+		if !a.IsActive || !active[a.Domain] {
+			continue
+		}
+
+		forwards := a.Targets
+		if len(forwards) == 0 {
+			continue
+		}
+
+		if _, isMailbox := maps[ExportVirtualMailboxes][a.Alias]; !isMailbox {
+			maps[ExportVirtualAliases][a.Alias] = strings.Join(forwards, ",")
+		}
+
+		logins := forwards
+		if owner, ok := maps[ExportSenderLoginMaps][a.Alias]; ok {
+			logins = append([]string{owner}, removeAddress(forwards, owner)...)
+		}
+		maps[ExportSenderLoginMaps][a.Alias] = strings.Join(logins, ",")
+	}
+
+	for _, ad := range aliasDomains {
+		if local[ad.TargetDomain] {
+			maps[ExportVirtualDomains][ad.AliasDomain] = "OK"
+			addAliasDomain(maps, ad.AliasDomain, ad.TargetDomain)
+		}
+	}
+
+	return maps, nil
+}
+
+// adds user@alias-domain for every address of the target domain. Hash maps can't keep
+// the local part of a catch-all, @alias-domain gets the forwards of @target-domain.
+func addAliasDomain(maps map[string]map[string]string, aliasDomain string, targetDomain string) {
+
+	for _, name := range []string{ExportVirtualAliases, ExportSenderLoginMaps} {
+
+		var keys []string
+		for k := range maps[name] {
+			if domainPart(k) == targetDomain {
+				keys = append(keys, k)
+			}
+		}
+
+		for _, k := range keys {
+
+			key := localPart(k) + "@" + aliasDomain
+
+			if name == ExportVirtualAliases && !alias.IsCatchAll(k) {
+				maps[name][key] = k
+			} else {
+				maps[name][key] = maps[name][k]
+			}
+		}
+	}
+}
+
+func removeAddress(addresses []string, address string) []string {
+
+	var result []string
+
+	for _, a := range addresses {
+		if a != address {
+			result = append(result, a)
+		}
+	}
+
+	return result
+}
+
+func formatExportMap(m map[string]string) []byte {
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+
+	buf.WriteString(exportHeader)
+
+	for _, k := range keys {
+		buf.WriteString(k + " " + m[k] + "\n")
+	}
+
+	return buf.Bytes()
+}
+
+// writes through a temporary file, so that postmap never sees half a file
+func writeIfChanged(file string, content []byte) (bool, error) {
+
+	existing, err := ioutil.ReadFile(file)
+	if err == nil && bytes.Equal(existing, content) {
+		return false, nil
+	}
+
+	tmp := file + ".tmp"
+
+	err = ioutil.WriteFile(tmp, content, 0640)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.Rename(tmp, file)
+	if err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,388 @@
+package postfix
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"swordlord.com/bunny-express/db/alias"
+	"swordlord.com/bunny-express/db/aliasdomain"
+	"swordlord.com/bunny-express/db/domain"
+	"swordlord.com/bunny-express/db/mailbox"
+)
+
+// names of the lookup tables served, same as the main.cf parameters they are used for
+const (
+	MapVirtualMailboxDomains = "virtual_mailbox_domains"
+	MapVirtualMailboxMaps    = "virtual_mailbox_maps"
+	MapVirtualAliasMaps      = "virtual_alias_maps"
+	MapSenderLoginMaps       = "smtpd_sender_login_maps"
+	MapRelayDomains          = "relay_domains"
+	MapTransportMaps         = "transport_maps"
+)
+
+var ErrNotFound = errors.New("not found")
+var ErrUnknownMap = errors.New("unknown map")
+
+// Lookup answers a Postfix table lookup of key in the named map. Returns ErrNotFound
+// when there is no result, ErrUnknownMap for unknown names and any other error when
+// the lookup could not be done.
+func Lookup(name string, key string) (string, error) {
+
+	switch name {
+	case MapVirtualMailboxDomains:
+		return lookupDomain(key)
+	case MapVirtualMailboxMaps:
+		return lookupMailbox(key)
+	case MapVirtualAliasMaps:
+		return lookupAlias(key)
+	case MapSenderLoginMaps:
+		return lookupSenderLogin(key)
+	case MapRelayDomains:
+		return lookupRelayDomain(key)
+	case MapTransportMaps:
+		return lookupTransport(key)
+	default:
+		return "", ErrUnknownMap
+	}
+}
+
+// IsMap tells if name is one of the maps served by Lookup.
+func IsMap(name string) bool {
+
+	switch name {
+	case MapVirtualMailboxDomains, MapVirtualMailboxMaps, MapVirtualAliasMaps, MapSenderLoginMaps, MapRelayDomains, MapTransportMaps:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetMailboxPath returns the maildir of the mailbox relative to virtual_mailbox_base,
+// same rules as the generated sqlite configs.
+func GetMailboxPath(m *mailbox.Mailbox) string {
+
+	if m.MailDir != "" {
+		return m.MailDir
+	}
+
+	return m.Domain + "/" + localPart(m.Mail) + "/"
+}
+
+func localPart(address string) string {
+
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return address
+	}
+
+	return address[:i]
+}
+
+func domainPart(address string) string {
+
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return ""
+	}
+
+	return address[i+1:]
+}
+
+func isActiveDomain(name string) (bool, error) {
+
+	d, err := getActiveDomain(name)
+
+	return d != nil, err
+}
+
+// returns the domain if it is active, nil otherwise
+func getActiveDomain(name string) (*domain.Domain, error) {
+
+	d, err := domain.GetDomain(name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !d.IsActive {
+		return nil, nil
+	}
+
+	return d, nil
+}
+
+// mail of virtual and alias domains is rewritten or delivered here, the one of relay
+// and backup MX domains is passed on
+func isLocalType(t string) bool {
+
+	return t == domain.TypeVirtual || t == domain.TypeAlias
+}
+
+// returns the mailbox if it and its domain are active, nil otherwise
+func getActiveMailbox(address string) (*mailbox.Mailbox, error) {
+
+	return activeMailbox(mailbox.GetMailbox(address))
+}
+
+// same as getActiveMailbox, but the login may be an address of an alias domain
+func getActiveMailboxByLogin(login string) (*mailbox.Mailbox, error) {
+
+	return activeMailbox(mailbox.GetMailboxByLogin(login))
+}
+
+func activeMailbox(m *mailbox.Mailbox, err error) (*mailbox.Mailbox, error) {
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !m.IsActive {
+		return nil, nil
+	}
+
+	active, err := isActiveDomain(m.Domain)
+	if err != nil || !active {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// returns the alias if it and its domain are active, nil otherwise
+func getActiveAlias(address string) (*alias.Alias, error) {
+
+	a, err := alias.GetAlias(address)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !a.IsActive {
+		return nil, nil
+	}
+
+	active, err := isActiveDomain(a.Domain)
+	if err != nil || !active {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func lookupDomain(key string) (string, error) {
+
+	d, err := getActiveDomain(key)
+	if err != nil {
+		return "", err
+	}
+
+	if d == nil {
+		// alias domains are accepted as long as their target is
+		ad, err := aliasdomain.GetAliasDomain(key)
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		} else if err != nil {
+			return "", err
+		}
+
+		d, err = getActiveDomain(ad.TargetDomain)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if d == nil || !isLocalType(d.Type) {
+		return "", ErrNotFound
+	}
+
+	return key, nil
+}
+
+func lookupRelayDomain(key string) (string, error) {
+
+	d, err := getActiveDomain(key)
+	if err != nil {
+		return "", err
+	}
+
+	if d == nil || isLocalType(d.Type) {
+		return "", ErrNotFound
+	}
+
+	return key, nil
+}
+
+// only domains have a transport, addresses are not found so Postfix asks for their
+// domain next
+func lookupTransport(key string) (string, error) {
+
+	d, err := getActiveDomain(key)
+	if err != nil {
+		return "", err
+	}
+
+	if d == nil || d.Transport.String == "" {
+		return "", ErrNotFound
+	}
+
+	return d.Transport.String, nil
+}
+
+func lookupMailbox(key string) (string, error) {
+
+	m, err := getActiveMailbox(key)
+	if err != nil {
+		return "", err
+	}
+
+	if m == nil {
+		return "", ErrNotFound
+	}
+
+	return GetMailboxPath(m), nil
+}
+
+// resolves in this order: an active mailbox maps to itself, so that a catch-all of its
+// domain does not take its mail, then the alias of the address, then the catch-all of
+// the domain. Addresses of an alias domain map to the target domain, if the address
+// is known there. Same order as the sqlite config and the exported maps.
+func lookupAlias(key string) (string, error) {
+
+	m, err := getActiveMailbox(key)
+	if err != nil {
+		return "", err
+	}
+
+	if m != nil {
+		return m.Mail, nil
+	}
+
+	a, err := getActiveAlias(key)
+	if err != nil {
+		return "", err
+	}
+
+	if a == nil && !alias.IsCatchAll(key) && domainPart(key) != "" {
+
+		a, err = getActiveAlias(alias.GetCatchAllAddress(domainPart(key)))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if a == nil && localPart(key) != "" {
+		return lookupAliasDomain(key)
+	}
+
+	if a == nil {
+		return "", ErrNotFound
+	}
+
+	if len(a.Targets) == 0 {
+		return "", ErrNotFound
+	}
+
+	return strings.Join(a.Targets, ","), nil
+}
+
+// rewrites user@alias-domain to user@target-domain, not found when the target address
+// does not resolve itself
+func lookupAliasDomain(key string) (string, error) {
+
+	target, err := aliasdomain.GetTargetAddress(key)
+	if err != nil {
+		return "", err
+	}
+
+	if target == "" {
+		return "", ErrNotFound
+	}
+
+	_, err = lookupAlias(target)
+	if err != nil {
+		return "", err
+	}
+
+	return target, nil
+}
+
+// the owner of a mailbox is the mailbox itself, the ones of an alias are its forwards.
+// Postfix asks for @domain itself, so the owners of a catch-all are found as well.
+func lookupSenderLogin(key string) (string, error) {
+
+	var logins []string
+
+	m, err := getActiveMailbox(key)
+	if err != nil {
+		return "", err
+	}
+
+	if m != nil {
+		logins = append(logins, m.Mail)
+	}
+
+	a, err := getActiveAlias(key)
+	if err != nil {
+		return "", err
+	}
+
+	if a != nil {
+		for _, fa := range a.Targets {
+			if m == nil || fa != m.Mail {
+				logins = append(logins, fa)
+			}
+		}
+	}
+
+	if len(logins) == 0 {
+		// the owners of user@alias-domain are the ones of user@target-domain
+		target, err := aliasdomain.GetTargetAddress(key)
+		if err != nil {
+			return "", err
+		}
+
+		if target == "" {
+			return "", ErrNotFound
+		}
+
+		return lookupSenderLogin(target)
+	}
+
+	return strings.Join(logins, ","), nil
+}
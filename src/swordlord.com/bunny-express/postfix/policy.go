@@ -0,0 +1,253 @@
+package postfix
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bufio"
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"swordlord.com/bunny-express/db/mailbox"
+	"swordlord.com/bunny-express/db/sendlog"
+	"sync"
+	"time"
+)
+
+const (
+	policyMaxLineLength = 4096
+	policyMaxAttributes = 100
+	// messages not finished within this time are forgotten
+	policyPendingTimeout = time.Hour
+)
+
+const (
+	actionDunno  = "DUNNO"
+	actionDefer  = "DEFER"
+	actionReject = "REJECT"
+)
+
+// PolicyServer answers Postfix policy delegation requests. It enforces the send limits
+// of the mailbox a client authenticated with. Recipients are checked at RCPT TO, the
+// message is counted at END-OF-MESSAGE.
+type PolicyServer struct {
+	mu sync.Mutex
+	// recipients accepted so far per message, by Postfix queue instance
+	pending map[string]*pendingMessage
+}
+
+type pendingMessage struct {
+	recipients int
+	started    time.Time
+}
+
+func NewPolicyServer() *PolicyServer {
+
+	return &PolicyServer{pending: make(map[string]*pendingMessage)}
+}
+
+// Handle answers policy requests on conn until the client closes the connection.
+func (p *PolicyServer) Handle(conn net.Conn) {
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, policyMaxLineLength), policyMaxLineLength)
+
+	for {
+		request, err := readPolicyRequest(scanner)
+		if err != nil {
+			if err != io.EOF {
+				common.LogDebug("Policy connection closed.", logrus.Fields{"error": err})
+			}
+			return
+		}
+
+		_, err = io.WriteString(conn, "action="+p.decide(request)+"\n\n")
+		if err != nil {
+			common.LogDebug("Could not write policy reply.", logrus.Fields{"error": err})
+			return
+		}
+	}
+}
+
+// reads name=value lines up to the empty line ending a request
+func readPolicyRequest(scanner *bufio.Scanner) (map[string]string, error) {
+
+	request := make(map[string]string)
+
+	for scanner.Scan() {
+
+		line := scanner.Text()
+		if line == "" {
+			return request, nil
+		}
+
+		if len(request) >= policyMaxAttributes {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			request[parts[0]] = parts[1]
+		}
+	}
+
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+
+	return nil, io.EOF
+}
+
+// returns the action for the request, with reason text for DEFER and REJECT
+func (p *PolicyServer) decide(request map[string]string) string {
+
+	user := request["sasl_username"]
+	if user == "" {
+		// not authenticated, not ours to limit
+		return actionDunno
+	}
+
+	m, err := getActiveMailboxByLogin(user)
+	if err != nil {
+		common.LogError("Policy lookup failed.", logrus.Fields{"sasl_username": user, "error": err})
+		return actionDefer + " 4.3.0 Temporary lookup failure"
+	}
+
+	if m == nil {
+
+		_, err = mailbox.GetMailboxByLogin(user)
+		if err == sql.ErrNoRows {
+			// authenticated against something else than us
+			return actionDunno
+		}
+
+		common.LogInfo("Policy rejected inactive mailbox.", logrus.Fields{"sasl_username": user})
+		return actionReject + " 5.7.1 Mailbox is disabled"
+	}
+
+	switch strings.ToUpper(request["protocol_state"]) {
+	case "RCPT":
+		return p.checkRecipient(m, request["instance"])
+	case "END-OF-MESSAGE":
+		p.recordMessage(m, request["instance"], request["recipient_count"])
+	}
+
+	return actionDunno
+}
+
+// checks if one more recipient is within the limits of the mailbox
+func (p *PolicyServer) checkRecipient(m *mailbox.Mailbox, instance string) string {
+
+	p.mu.Lock()
+	p.expirePending()
+	pending, ok := p.pending[instance]
+	if !ok {
+		pending = &pendingMessage{started: time.Now()}
+		p.pending[instance] = pending
+	}
+	recipients := pending.recipients
+	p.mu.Unlock()
+
+	limits := []struct {
+		period  time.Duration
+		maxMsg  int
+		maxRcpt int
+	}{
+		{time.Hour, m.MaxMsgHour, m.MaxRcptHour},
+		{24 * time.Hour, m.MaxMsgDay, m.MaxRcptDay},
+	}
+
+	for _, l := range limits {
+
+		if l.maxMsg == 0 && l.maxRcpt == 0 {
+			continue
+		}
+
+		counts, err := sendlog.GetCounts(m.Mail, l.period)
+		if err != nil {
+			common.LogError("Could not read send log.", logrus.Fields{"mail": m.Mail, "error": err})
+			return actionDefer + " 4.3.0 Temporary lookup failure"
+		}
+
+		// the message limit is checked at the first recipient of a message only
+		if l.maxMsg > 0 && recipients == 0 && counts.Messages >= l.maxMsg ||
+			l.maxRcpt > 0 && counts.Recipients+recipients >= l.maxRcpt {
+
+			common.LogInfo("Send limit exceeded.", logrus.Fields{"mail": m.Mail, "period": l.period.String(),
+				"messages": counts.Messages, "recipients": counts.Recipients + recipients})
+
+			return actionDefer + " 4.7.1 Send limit exceeded, try again later"
+		}
+	}
+
+	p.mu.Lock()
+	pending.recipients++
+	p.mu.Unlock()
+
+	return actionDunno
+}
+
+// counts the finished message in the send log
+func (p *PolicyServer) recordMessage(m *mailbox.Mailbox, instance string, recipientCount string) {
+
+	p.mu.Lock()
+	pending, ok := p.pending[instance]
+	delete(p.pending, instance)
+	p.mu.Unlock()
+
+	recipients, err := strconv.Atoi(recipientCount)
+	if err != nil || recipients == 0 {
+		if !ok {
+			return
+		}
+		recipients = pending.recipients
+	}
+
+	err = sendlog.AddEntry(m.Mail, recipients)
+	if err != nil {
+		common.LogError("Could not write send log.", logrus.Fields{"mail": m.Mail, "error": err})
+	}
+}
+
+// forgets messages which never reached END-OF-MESSAGE, to be called with mu held
+func (p *PolicyServer) expirePending() {
+
+	for instance, pending := range p.pending {
+		if time.Since(pending.started) > policyPendingTimeout {
+			delete(p.pending, instance)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package postfix
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"swordlord.com/bunny-express/common"
+)
+
+// Postfix limits socketmap requests and replies to 100000 bytes
+const socketmapMaxLength = 100000
+
+var errNetstringFormat = errors.New("malformed netstring")
+
+// HandleSocketmap answers socketmap requests on conn until the client closes the
+// connection. Postfix keeps connections open and sends one netstring per lookup.
+func HandleSocketmap(conn net.Conn) {
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		request, err := readNetstring(r, socketmapMaxLength)
+		if err != nil {
+			if err != io.EOF {
+				common.LogDebug("Socketmap connection closed.", logrus.Fields{"error": err})
+			}
+			return
+		}
+
+		reply := socketmapReply(request)
+
+		err = writeNetstring(w, reply)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			common.LogDebug("Could not write socketmap reply.", logrus.Fields{"error": err})
+			return
+		}
+	}
+}
+
+// builds the reply for a "name key" request
+func socketmapReply(request string) string {
+
+	parts := strings.SplitN(request, " ", 2)
+	if len(parts) != 2 {
+		return "PERM malformed request"
+	}
+
+	name, key := parts[0], parts[1]
+
+	value, err := Lookup(name, key)
+	switch {
+	case err == nil:
+		common.LogDebug("Socketmap lookup.", logrus.Fields{"map": name, "key": key, "value": value})
+		return "OK " + value
+	case err == ErrNotFound:
+		common.LogDebug("Socketmap lookup, not found.", logrus.Fields{"map": name, "key": key})
+		return "NOTFOUND "
+	case err == ErrUnknownMap:
+		return "PERM unknown map name " + name
+	default:
+		common.LogError("Socketmap lookup failed.", logrus.Fields{"map": name, "key": key, "error": err})
+		return "TEMP lookup failed"
+	}
+}
+
+// reads a netstring: <length>:<data>,
+func readNetstring(r *bufio.Reader, maxLength int) (string, error) {
+
+	// digits only, and not more than maxLength has
+	maxDigits := len(strconv.Itoa(maxLength))
+	length := 0
+
+	for i := 0; ; i++ {
+
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				return "", errNetstringFormat
+			}
+			return "", err
+		}
+
+		if c == ':' && i > 0 {
+			break
+		}
+
+		if c < '0' || c > '9' || i == maxDigits {
+			return "", errNetstringFormat
+		}
+
+		length = length*10 + int(c-'0')
+	}
+
+	if length > maxLength {
+		return "", errNetstringFormat
+	}
+
+	data := make([]byte, length+1)
+	_, err := io.ReadFull(r, data)
+	if err != nil {
+		return "", err
+	}
+
+	if data[length] != ',' {
+		return "", errNetstringFormat
+	}
+
+	return string(data[:length]), nil
+}
+
+func writeNetstring(w io.Writer, data string) error {
+
+	_, err := fmt.Fprintf(w, "%d:%s,", len(data), data)
+
+	return err
+}
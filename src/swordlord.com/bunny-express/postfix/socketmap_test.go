@@ -0,0 +1,133 @@
+package postfix
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadNetstring(t *testing.T) {
+
+	tests := []struct {
+		input string
+		want  []string
+		err   error
+	}{
+		{"0:,", []string{""}, io.EOF},
+		{"5:hello,", []string{"hello"}, io.EOF},
+		{"5:hello,6:world!,", []string{"hello", "world!"}, io.EOF},
+		{"14:virtual u@x.ch,", []string{"virtual u@x.ch"}, io.EOF},
+		{"", nil, io.EOF},
+		{"5:hello;", nil, errNetstringFormat},
+		{"5:hell", nil, io.ErrUnexpectedEOF},
+		{"5:hello", nil, io.ErrUnexpectedEOF},
+		{"5", nil, errNetstringFormat},
+		{":hello,", nil, errNetstringFormat},
+		{"-1:,", nil, errNetstringFormat},
+		{"+5:hello,", nil, errNetstringFormat},
+		{" 5:hello,", nil, errNetstringFormat},
+		{"x:hello,", nil, errNetstringFormat},
+		{"5:hello,x", []string{"hello"}, errNetstringFormat},
+		// above the maximum, without reading the data
+		{"21:hello world, hello bunny,", nil, errNetstringFormat},
+		{"001:x,", nil, errNetstringFormat},
+		{strings.Repeat("1", 1000), nil, errNetstringFormat},
+	}
+
+	for _, tt := range tests {
+
+		r := bufio.NewReader(strings.NewReader(tt.input))
+
+		var got []string
+		var err error
+
+		for {
+			var s string
+			s, err = readNetstring(r, 20)
+			if err != nil {
+				break
+			}
+			got = append(got, s)
+		}
+
+		if err != tt.err || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("readNetstring(%q) = %q, %v, want %q, %v", tt.input, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestWriteNetstring(t *testing.T) {
+
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"", "0:,"},
+		{"OK /var/mail/x.ch/u/", "20:OK /var/mail/x.ch/u/,"},
+		{"NOTFOUND ", "9:NOTFOUND ,"},
+		// the length counts bytes, not characters
+		{"OK bünny", "9:OK bünny,"},
+	}
+
+	for _, tt := range tests {
+
+		var b bytes.Buffer
+
+		err := writeNetstring(&b, tt.data)
+		if err != nil || b.String() != tt.want {
+			t.Errorf("writeNetstring(%q) = %q, %v, want %q", tt.data, b.String(), err, tt.want)
+		}
+
+		// what is written can be read again
+		s, err := readNetstring(bufio.NewReader(&b), socketmapMaxLength)
+		if err != nil || s != tt.data {
+			t.Errorf("readNetstring(writeNetstring(%q)) = %q, %v", tt.data, s, err)
+		}
+	}
+}
+
+func TestSocketmapReplyMalformed(t *testing.T) {
+
+	tests := []string{"", "virtual_mailbox_maps", "nokey"}
+
+	for _, request := range tests {
+
+		if got := socketmapReply(request); got != "PERM malformed request" {
+			t.Errorf("socketmapReply(%q) = %q, want PERM", request, got)
+		}
+	}
+}
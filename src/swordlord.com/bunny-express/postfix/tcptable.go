@@ -0,0 +1,119 @@
+package postfix
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"swordlord.com/bunny-express/common"
+)
+
+// Postfix limits tcp_table requests and replies to 4096 bytes
+const tcpTableMaxLength = 4096
+
+// NewTcpTableHandler returns a handler answering tcp_table requests on the named map.
+// Postfix keeps connections open and sends one "get key" line per lookup.
+func NewTcpTableHandler(name string) func(net.Conn) {
+
+	return func(conn net.Conn) {
+
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, tcpTableMaxLength), tcpTableMaxLength)
+
+		for scanner.Scan() {
+
+			reply := tcpTableReply(name, scanner.Text())
+
+			_, err := io.WriteString(conn, reply+"\n")
+			if err != nil {
+				common.LogDebug("Could not write tcp_table reply.", logrus.Fields{"error": err})
+				return
+			}
+		}
+
+		if scanner.Err() != nil {
+			common.LogDebug("Tcp_table connection closed.", logrus.Fields{"error": scanner.Err()})
+		}
+	}
+}
+
+// builds the reply for a "get key" request, keys and values are %XX encoded
+func tcpTableReply(name string, request string) string {
+
+	request = strings.TrimSuffix(request, "\r")
+
+	if !strings.HasPrefix(request, "get ") {
+		return "400 " + tcpTableEncode("unsupported request")
+	}
+
+	key, err := url.PathUnescape(strings.TrimPrefix(request, "get "))
+	if err != nil {
+		return "400 " + tcpTableEncode("malformed key")
+	}
+
+	value, err := Lookup(name, key)
+	switch {
+	case err == nil:
+		common.LogDebug("Tcp_table lookup.", logrus.Fields{"map": name, "key": key, "value": value})
+		return "200 " + tcpTableEncode(value)
+	case err == ErrNotFound:
+		common.LogDebug("Tcp_table lookup, not found.", logrus.Fields{"map": name, "key": key})
+		return "500 " + tcpTableEncode("not found")
+	default:
+		common.LogError("Tcp_table lookup failed.", logrus.Fields{"map": name, "key": key, "error": err})
+		return "400 " + tcpTableEncode("lookup failed")
+	}
+}
+
+// encodes whitespace, control characters, non ASCII and % as %XX
+func tcpTableEncode(s string) string {
+
+	var sb strings.Builder
+
+	for i := 0; i < len(s); i++ {
+
+		c := s[i]
+		if c <= 32 || c >= 127 || c == '%' {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String()
+}
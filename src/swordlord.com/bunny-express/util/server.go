@@ -0,0 +1,259 @@
+package util
+
+/*-----------------------------------------------------------------------------
+ ** ______                           _______
+ **|   __ \.--.--.-----.-----.--.--.|    ___|.--.--.-----.----.-----.-----.-----.
+ **|   __ <|  |  |     |     |  |  ||    ___||_   _|  _  |   _|  -__|__ --|__ --|
+ **|______/|_____|__|__|__|__|___  ||_______||__.__|   __|__| |_____|_____|_____|
+ **                          |_____|               |__|
+ **
+ ** CLI-based tool for postfix / dovecot user administration
+ **
+ ** Copyright 2018-19 by SwordLord - the coding crew - http://www.swordlord.com
+ ** and contributing authors
+ **
+ ** This program is free software; you can redistribute it and/or modify it
+ ** under the terms of the GNU Affero General Public License as published by the
+ ** Free Software Foundation, either version 3 of the License, or (at your option)
+ ** any later version.
+ **
+ ** This program is distributed in the hope that it will be useful, but WITHOUT
+ ** ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ ** FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ ** for more details.
+ **
+ ** You should have received a copy of the GNU Affero General Public License
+ ** along with this program. If not, see <http://www.gnu.org/licenses/>.
+ **
+ **-----------------------------------------------------------------------------
+ **
+ ** Original Authors:
+ ** LordEidi@swordlord.com
+ **
+-----------------------------------------------------------------------------*/
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"swordlord.com/bunny-express/common"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// time given to running HTTP requests when shutting down
+const httpShutdownTimeout = 10 * time.Second
+
+// Server accepts connections on any number of listeners and hands them to the handler
+// of the listener. Serve blocks until SIGTERM or SIGINT, then shuts down gracefully.
+type Server struct {
+	listeners   []net.Listener
+	handlers    map[net.Listener]func(net.Conn)
+	httpServers map[net.Listener]*http.Server
+	conns       map[net.Conn]bool
+	closing     bool
+	mu          sync.Mutex
+	wg          sync.WaitGroup
+}
+
+func NewServer() *Server {
+
+	return &Server{handlers: make(map[net.Listener]func(net.Conn)),
+		httpServers: make(map[net.Listener]*http.Server), conns: make(map[net.Conn]bool)}
+}
+
+// Listen adds a listener on unix:/path/to/socket or tcp:host:port (tcp: is optional).
+// Sockets get the given file mode. Connections accepted on it are passed to handler.
+func (s *Server) Listen(address string, socketMode os.FileMode, handler func(net.Conn)) (net.Listener, error) {
+
+	l, err := listen(address, socketMode)
+	if err != nil {
+		return nil, err
+	}
+
+	s.listeners = append(s.listeners, l)
+	s.handlers[l] = handler
+
+	return l, nil
+}
+
+// ListenHTTP is Listen for HTTP, requests are passed to handler.
+func (s *Server) ListenHTTP(address string, socketMode os.FileMode, handler http.Handler) (net.Listener, error) {
+
+	l, err := listen(address, socketMode)
+	if err != nil {
+		return nil, err
+	}
+
+	s.listeners = append(s.listeners, l)
+	s.httpServers[l] = &http.Server{Handler: handler, ReadTimeout: time.Minute}
+
+	return l, nil
+}
+
+func listen(address string, socketMode os.FileMode) (net.Listener, error) {
+
+	var l net.Listener
+	var err error
+
+	if strings.HasPrefix(address, "unix:") {
+
+		path := strings.TrimPrefix(address, "unix:")
+
+		err = removeStaleSocket(path)
+		if err != nil {
+			return nil, err
+		}
+
+		l, err = net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = os.Chmod(path, socketMode)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+
+	} else {
+
+		l, err = net.Listen("tcp", strings.TrimPrefix(address, "tcp:"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// removes the socket of an earlier run which nobody listens on anymore, but neither
+// other files nor sockets in use
+func removeStaleSocket(path string) error {
+
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is no socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// Serve accepts connections until SIGTERM or SIGINT. Then listeners are closed, blocked
+// reads are interrupted and running handlers are waited for.
+func (s *Server) Serve() {
+
+	for _, l := range s.listeners {
+
+		common.LogInfo("Listening.", logrus.Fields{"address": l.Addr().String()})
+
+		hs, ok := s.httpServers[l]
+		if ok {
+			go s.serveHTTP(hs, l)
+		} else {
+			go s.accept(l)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-signals
+	common.LogInfo("Shutting down.", logrus.Fields{"signal": sig.String()})
+
+	s.mu.Lock()
+	s.closing = true
+	for _, l := range s.listeners {
+		if _, ok := s.httpServers[l]; !ok {
+			l.Close()
+		}
+	}
+	for c := range s.conns {
+		c.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	// closes the listener and waits for running requests
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	for _, hs := range s.httpServers {
+		hs.Shutdown(ctx)
+	}
+
+	s.wg.Wait()
+}
+
+func (s *Server) serveHTTP(hs *http.Server, l net.Listener) {
+
+	err := hs.Serve(l)
+	if err != http.ErrServerClosed {
+		common.LogError("HTTP server failed.", logrus.Fields{"address": l.Addr().String(), "error": err})
+	}
+}
+
+func (s *Server) accept(l net.Listener) {
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+
+			if closing {
+				return
+			}
+
+			common.LogError("Could not accept connection.", logrus.Fields{"address": l.Addr().String(), "error": err})
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.handle(conn, s.handlers[l])
+	}
+}
+
+func (s *Server) handle(conn net.Conn, handler func(net.Conn)) {
+
+	defer func() {
+		conn.Close()
+
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+
+		s.wg.Done()
+	}()
+
+	handler(conn)
+}